@@ -0,0 +1,183 @@
+// Command ping sends ICMP echo requests to one or more hosts using package
+// ping. It prints ping(8)-style lines and statistics, or JSON for scripts:
+//
+//	ping [-c count] [-i interval] [-W timeout] [-s size] [-4|-6] [-json|-ndjson] host...
+//
+// Hosts are probed concurrently. The exit status is 0 when every host
+// answered, 1 when some host did not, and 2 on other errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"tools/ping"
+)
+
+// defaultSize is the payload size of ping(8).
+const defaultSize = 56
+
+// session is the outcome of pinging one host.
+type session struct {
+	host    string
+	ip      net.IP
+	stats   *ping.Statistics
+	elapsed time.Duration
+	err     error
+}
+
+func main() {
+	count := flag.Int("c", ping.DefaultCount, "stop after `count` probes; 0 runs until interrupted")
+	interval := flag.Duration("i", ping.DefaultInterval, "`interval` between probes")
+	timeout := flag.Duration("W", ping.DefaultTimeout, "`timeout` for each reply")
+	size := flag.Int("s", defaultSize, "payload `size` in bytes")
+	v4 := flag.Bool("4", false, "use IPv4 only")
+	v6 := flag.Bool("6", false, "use IPv6 only")
+	asJSON := flag.Bool("json", false, "print one JSON document when done")
+	asNDJSON := flag.Bool("ndjson", false, "print one JSON object per reply and per summary")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] host...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *v4 && *v6 {
+		fatalf("-4 and -6 are mutually exclusive")
+	}
+	if *asJSON && *asNDJSON {
+		fatalf("-json and -ndjson are mutually exclusive")
+	}
+	if *interval <= 0 {
+		fatalf("bad interval %v", *interval)
+	}
+	if *timeout < 0 {
+		fatalf("bad timeout %v", *timeout)
+	}
+	if *size < ping.MinPayloadSize || *size > 65507 {
+		fatalf("bad payload size %d: must be between %d and 65507", *size, ping.MinPayloadSize)
+	}
+
+	fam := ping.FamilyAuto
+	switch {
+	case *v4:
+		fam = ping.FamilyIPv4
+	case *v6:
+		fam = ping.FamilyIPv6
+	}
+
+	var out printer
+	switch {
+	case *asJSON:
+		out = newJSONPrinter(os.Stdout, false)
+	case *asNDJSON:
+		out = newJSONPrinter(os.Stdout, true)
+	default:
+		out = newTextPrinter(os.Stdout, os.Stderr, *size)
+	}
+
+	// The first interrupt ends the sessions with their statistics, like
+	// ping(8); a second one kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+		signal.Stop(sig)
+	}()
+
+	sessions := make([]session, flag.NArg())
+	var wg sync.WaitGroup
+	for i, host := range flag.Args() {
+		sessions[i].host = host
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			run(ctx, s, fam, *count, *interval, *timeout, *size, out)
+		}(&sessions[i])
+	}
+	wg.Wait()
+	out.finish(sessions)
+
+	os.Exit(exitCode(sessions))
+}
+
+// run pings the host of s until its count is reached or ctx is done.
+func run(ctx context.Context, s *session, fam ping.Family, count int, interval, timeout time.Duration, size int, out printer) {
+	s.ip, s.err = lookup(ctx, s.host, fam)
+	if s.err != nil {
+		out.failed(s)
+		return
+	}
+
+	p := ping.NewPinger(s.ip.String())
+	p.Family = fam
+	p.Count = count
+	p.Interval = interval
+	p.Timeout = timeout
+	p.Size = size
+	p.OnReply = func(r ping.Result) { out.reply(s, r) }
+	defer context.AfterFunc(ctx, p.Stop)()
+
+	out.start(s)
+	start := time.Now()
+	s.stats, s.err = p.Run()
+	s.elapsed = time.Since(start)
+	if s.err != nil {
+		out.failed(s)
+		return
+	}
+	out.summary(s)
+}
+
+// lookup resolves host to its first address of family fam.
+func lookup(ctx context.Context, host string, fam ping.Family) (net.IP, error) {
+	network := "ip"
+	switch fam {
+	case ping.FamilyIPv4:
+		network = "ip4"
+	case ping.FamilyIPv6:
+		network = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no address found")
+	}
+	return ips[0], nil
+}
+
+// exitCode follows ping(8): 0 when every host answered, 1 when one did not,
+// and 2 when one could not be pinged at all.
+func exitCode(sessions []session) int {
+	code := 0
+	for _, s := range sessions {
+		switch {
+		case s.err != nil:
+			return 2
+		case s.stats.Received == 0:
+			code = 1
+		}
+	}
+	return code
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "ping: "+format+"\n", args...)
+	os.Exit(2)
+}
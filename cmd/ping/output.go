@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"tools/ping"
+)
+
+// printer reports the progress of the sessions. Its methods may be called
+// from several sessions at once.
+type printer interface {
+	start(s *session)
+	reply(s *session, r ping.Result)
+	summary(s *session)
+	failed(s *session)
+	finish(sessions []session)
+}
+
+// textPrinter prints the output of ping(8): progress and statistics to w,
+// errors to errw.
+type textPrinter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	errw io.Writer
+	size int
+}
+
+func newTextPrinter(w, errw io.Writer, size int) *textPrinter {
+	return &textPrinter{w: w, errw: errw, size: size}
+}
+
+func (p *textPrinter) start(s *session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Packet size: payload, ICMP header and the minimal IP header.
+	hdr := 8 + 20
+	if s.ip.To4() == nil {
+		hdr = 8 + 40
+	}
+	fmt.Fprintf(p.w, "PING %s (%s) %d(%d) bytes of data.\n", s.host, s.ip, p.size, p.size+hdr)
+}
+
+func (p *textPrinter) reply(s *session, r ping.Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.w, replyLine(r))
+}
+
+func (p *textPrinter) summary(s *session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprint(p.w, summaryText(s))
+}
+
+func (p *textPrinter) failed(s *session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.errw, "ping: %s: %v\n", s.host, s.err)
+}
+
+func (p *textPrinter) finish([]session) {}
+
+// replyLine formats a reply as ping(8) does.
+func replyLine(r ping.Result) string {
+	if r.Err != nil && !r.Success {
+		var unreach *ping.UnreachableError
+		var exceeded *ping.TimeExceededError
+		switch {
+		case errors.As(r.Err, &unreach):
+			return fmt.Sprintf("From %s icmp_seq=%d Destination %s", unreach.From, r.Seq, unreach.Reason())
+		case errors.As(r.Err, &exceeded):
+			return fmt.Sprintf("From %s icmp_seq=%d Time to live exceeded", exceeded.From, r.Seq)
+		}
+	}
+	line := fmt.Sprintf("%d bytes from %s: icmp_seq=%d ttl=%d time=%s ms", r.Size, r.IP, r.Seq, r.TTL, formatRTT(r.RTT))
+	switch {
+	case r.Kind == ping.ReplyDuplicate:
+		line += " (DUP!)"
+	case r.Kind == ping.ReplyLate:
+		line += " (late)"
+	case r.Err != nil:
+		line += fmt.Sprintf(" (%v)", r.Err)
+	}
+	return line
+}
+
+// summaryText formats the statistics of a session as ping(8) does.
+func summaryText(s *session) string {
+	st := s.stats
+	text := fmt.Sprintf("\n--- %s ping statistics ---\n%d packets transmitted, %d received", s.host, st.Sent, st.Received)
+	if st.Duplicates > 0 {
+		text += fmt.Sprintf(", +%d duplicates", st.Duplicates)
+	}
+	if st.Corrupted > 0 {
+		text += fmt.Sprintf(", +%d corrupted", st.Corrupted)
+	}
+	if n := icmpErrors(st); n > 0 {
+		text += fmt.Sprintf(", +%d errors", n)
+	}
+	text += fmt.Sprintf(", %.6g%% packet loss, time %dms\n", st.PacketLoss, s.elapsed.Milliseconds())
+	if st.Received > 0 {
+		text += fmt.Sprintf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+			ms(st.MinRTT), ms(st.AvgRTT), ms(st.MaxRTT), ms(st.MdevRTT))
+	}
+	return text
+}
+
+// icmpErrors counts the probes answered with an ICMP error.
+func icmpErrors(st *ping.Statistics) int {
+	n := 0
+	for _, r := range st.Results {
+		var unreach *ping.UnreachableError
+		var exceeded *ping.TimeExceededError
+		if errors.As(r.Err, &unreach) || errors.As(r.Err, &exceeded) {
+			n++
+		}
+	}
+	return n
+}
+
+// formatRTT prints an RTT with the precision ping(8) uses for it.
+func formatRTT(d time.Duration) string {
+	v := ms(d)
+	switch {
+	case v >= 100:
+		return fmt.Sprintf("%.0f", v)
+	case v >= 10:
+		return fmt.Sprintf("%.1f", v)
+	case v >= 1:
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return fmt.Sprintf("%.3f", v)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// jsonReply is a reply in JSON output. Times are in milliseconds.
+type jsonReply struct {
+	Type    string  `json:"type,omitempty"`
+	Host    string  `json:"host,omitempty"`
+	IP      string  `json:"ip"`
+	Seq     int     `json:"seq"`
+	Success bool    `json:"success"`
+	Kind    string  `json:"kind"`
+	RTT     float64 `json:"rtt_ms,omitempty"`
+	TTL     int     `json:"ttl,omitempty"`
+	Size    int     `json:"size,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// jsonSummary is the outcome of a session in JSON output. Times are in
+// milliseconds.
+type jsonSummary struct {
+	Type       string      `json:"type,omitempty"`
+	Host       string      `json:"host"`
+	IP         string      `json:"ip,omitempty"`
+	Sent       int         `json:"sent"`
+	Received   int         `json:"received"`
+	Duplicates int         `json:"duplicates"`
+	Corrupted  int         `json:"corrupted"`
+	Late       int         `json:"late"`
+	Reordered  int         `json:"reordered"`
+	PacketLoss float64     `json:"packet_loss"`
+	Min        float64     `json:"min_ms"`
+	Avg        float64     `json:"avg_ms"`
+	Max        float64     `json:"max_ms"`
+	Mdev       float64     `json:"mdev_ms"`
+	P50        float64     `json:"p50_ms"`
+	P90        float64     `json:"p90_ms"`
+	P99        float64     `json:"p99_ms"`
+	Jitter     float64     `json:"jitter_ms"`
+	Time       int64       `json:"time_ms"`
+	Error      string      `json:"error,omitempty"`
+	Replies    []jsonReply `json:"replies,omitempty"`
+}
+
+func newJSONReply(r ping.Result) jsonReply {
+	j := jsonReply{IP: r.IP, Seq: r.Seq, Success: r.Success, Kind: r.Kind.String(), TTL: r.TTL, Size: r.Size}
+	if r.Kind != ping.ReplyNone {
+		j.RTT = ms(r.RTT)
+	}
+	if r.Err != nil {
+		j.Error = r.Err.Error()
+	}
+	return j
+}
+
+func newJSONSummary(s *session) jsonSummary {
+	j := jsonSummary{Host: s.host}
+	if s.ip != nil {
+		j.IP = s.ip.String()
+	}
+	if s.err != nil {
+		j.Error = s.err.Error()
+	}
+	if st := s.stats; st != nil {
+		j.Sent, j.Received = st.Sent, st.Received
+		j.Duplicates, j.Corrupted, j.Late, j.Reordered = st.Duplicates, st.Corrupted, st.Late, st.Reordered
+		j.PacketLoss = st.PacketLoss
+		j.Min, j.Avg, j.Max, j.Mdev = ms(st.MinRTT), ms(st.AvgRTT), ms(st.MaxRTT), ms(st.MdevRTT)
+		j.P50, j.P90, j.P99 = ms(st.Latency.P50), ms(st.Latency.P90), ms(st.Latency.P99)
+		j.Jitter = ms(st.Latency.Jitter)
+		j.Time = s.elapsed.Milliseconds()
+	}
+	return j
+}
+
+// jsonPrinter prints either one JSON array of sessions, with their replies,
+// when all are done, or one JSON object per line for every reply and
+// summary as they happen.
+type jsonPrinter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	stream bool
+}
+
+func newJSONPrinter(w io.Writer, stream bool) *jsonPrinter {
+	return &jsonPrinter{enc: json.NewEncoder(w), stream: stream}
+}
+
+func (p *jsonPrinter) start(s *session) {}
+
+func (p *jsonPrinter) reply(s *session, r ping.Result) {
+	if !p.stream {
+		return
+	}
+	j := newJSONReply(r)
+	j.Type, j.Host = "reply", s.host
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enc.Encode(j)
+}
+
+func (p *jsonPrinter) summary(s *session) {
+	if !p.stream {
+		return
+	}
+	j := newJSONSummary(s)
+	j.Type = "summary"
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enc.Encode(j)
+}
+
+func (p *jsonPrinter) failed(s *session) {
+	p.summary(s)
+}
+
+func (p *jsonPrinter) finish(sessions []session) {
+	if p.stream {
+		return
+	}
+	all := make([]jsonSummary, len(sessions))
+	for i := range sessions {
+		all[i] = newJSONSummary(&sessions[i])
+		if st := sessions[i].stats; st != nil {
+			for _, r := range st.Results {
+				all[i].Replies = append(all[i].Replies, newJSONReply(r))
+			}
+		}
+	}
+	p.enc.Encode(all)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tools/ping"
+)
+
+func TestReplyLine(t *testing.T) {
+	r := ping.Result{IP: "192.0.2.1", Seq: 3, Success: true, RTT: 12345 * time.Microsecond, Size: 64, TTL: 57, Kind: ping.ReplyNormal}
+	if got, want := replyLine(r), "64 bytes from 192.0.2.1: icmp_seq=3 ttl=57 time=12.3 ms"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	r.Kind = ping.ReplyDuplicate
+	if got := replyLine(r); !strings.HasSuffix(got, " (DUP!)") {
+		t.Errorf("duplicate not marked: %q", got)
+	}
+
+	r = ping.Result{IP: "192.0.2.1", Seq: 4, Err: &ping.UnreachableError{Family: ping.FamilyIPv4, Code: 1, From: net.ParseIP("198.51.100.1")}}
+	if got, want := replyLine(r), "From 198.51.100.1 icmp_seq=4 Destination host unreachable"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatRTT(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		45 * time.Microsecond:     "0.045",
+		2500 * time.Microsecond:   "2.50",
+		25 * time.Millisecond:     "25.0",
+		123456 * time.Microsecond: "123",
+	} {
+		if got := formatRTT(d); got != want {
+			t.Errorf("%v: got %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestSummaryText(t *testing.T) {
+	s := &session{host: "example.net", elapsed: 3004 * time.Millisecond, stats: &ping.Statistics{
+		Sent: 3, Received: 2, Duplicates: 1, PacketLoss: 100.0 / 3,
+		MinRTT: time.Millisecond, AvgRTT: 1500 * time.Microsecond, MaxRTT: 2 * time.Millisecond, MdevRTT: 500 * time.Microsecond,
+	}}
+	want := "\n--- example.net ping statistics ---\n" +
+		"3 packets transmitted, 2 received, +1 duplicates, 33.3333% packet loss, time 3004ms\n" +
+		"rtt min/avg/max/mdev = 1.000/1.500/2.000/0.500 ms\n"
+	if got := summaryText(s); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTextPrinter_Failed(t *testing.T) {
+	var out, errs bytes.Buffer
+	p := newTextPrinter(&out, &errs, 56)
+	p.failed(&session{host: "host.invalid", err: errors.New("no such host")})
+	if got, want := errs.String(), "ping: host.invalid: no such host\n"; got != want || out.Len() != 0 {
+		t.Errorf("got %q on the error writer and %q on the output, want %q", got, out.String(), want)
+	}
+}
+
+func TestJSONPrinter_Finish(t *testing.T) {
+	var buf bytes.Buffer
+	p := newJSONPrinter(&buf, false)
+	p.finish([]session{
+		{host: "a", ip: net.ParseIP("192.0.2.1"), stats: &ping.Statistics{Sent: 1, Received: 1, Results: []ping.Result{{IP: "192.0.2.1", Seq: 1, Success: true, Kind: ping.ReplyNormal}}}},
+		{host: "b", err: net.UnknownNetworkError("boom")},
+	})
+	var got []jsonSummary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Host != "a" || len(got[0].Replies) != 1 || got[1].Error == "" {
+		t.Errorf("unexpected output %s", buf.Bytes())
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	ok := session{stats: &ping.Statistics{Received: 1}}
+	lost := session{stats: &ping.Statistics{}}
+	failed := session{err: net.UnknownNetworkError("x")}
+	for _, c := range []struct {
+		sessions []session
+		want     int
+	}{
+		{[]session{ok, ok}, 0},
+		{[]session{ok, lost}, 1},
+		{[]session{lost, failed}, 2},
+	} {
+		if got := exitCode(c.sessions); got != c.want {
+			t.Errorf("got %d, want %d", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tools/loader"
+
+	"github.com/pelletier/go-toml"
+)
+
+// configTableName is the table of the loader configuration read by
+// LoadConfig.
+const configTableName = "ping"
+
+// Config describes the monitored targets, read from a [ping] table:
+//
+//	[ping]
+//	interval  = "30s" # defaults for every group
+//	timeout   = "1s"
+//	size      = 56
+//	downAfter = 3
+//	upAfter   = 2
+//
+//	[ping.groups.core]
+//	targets  = ["10.0.0.1", "gateway.example.net", "10.0.1.0/28"]
+//	interval = "5s"
+//
+// Durations are strings such as "1.5s" or whole seconds. Every setting of
+// the table is a default that groups may override.
+type Config struct {
+	Groups []GroupConfig // ordered by name
+}
+
+// GroupConfig describes a group of targets monitored together.
+type GroupConfig struct {
+	Name      string
+	Targets   []string // host names, addresses or CIDR blocks
+	Interval  time.Duration
+	Timeout   time.Duration
+	Size      int // payload bytes; 0 means MinPayloadSize
+	DownAfter int
+	UpAfter   int
+}
+
+// maxConfigSize is the largest payload a configuration may ask for.
+const maxConfigSize = 65507
+
+// LoadConfig reads and validates the [ping] table of the configuration
+// loaded with loader.LoadConfig.
+func LoadConfig() (*Config, error) {
+	t, err := loader.GetTable(configTableName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(t)
+}
+
+// ParseConfig validates a [ping] table. Errors name the offending key and
+// its line.
+func ParseConfig(t *toml.Tree) (*Config, error) {
+	defaults := GroupConfig{
+		Interval:  DefaultInterval,
+		Timeout:   DefaultTimeout,
+		DownAfter: DefaultDownAfter,
+		UpAfter:   DefaultUpAfter,
+	}
+	p := configParser{path: configTableName}
+	if err := p.group(t, &defaults, "groups"); err != nil {
+		return nil, err
+	}
+
+	groups, ok := t.GetPath([]string{"groups"}).(*toml.Tree)
+	if !ok {
+		return nil, p.errorf(t, "groups", "no target groups defined")
+	}
+	cfg := &Config{}
+	names := groups.Keys()
+	sort.Strings(names)
+	for _, name := range names {
+		gt, ok := groups.GetPath([]string{name}).(*toml.Tree)
+		if !ok {
+			return nil, p.errorf(groups, name, "must be a table")
+		}
+		g := defaults
+		g.Name = name
+		gp := configParser{path: configTableName + ".groups." + name}
+		if err := gp.group(gt, &g, "targets"); err != nil {
+			return nil, err
+		}
+		if len(g.Targets) == 0 {
+			return nil, gp.errorf(gt, "targets", "no targets")
+		}
+		s := NewSweeper(g.Targets...)
+		if _, err := s.parse(); err != nil {
+			return nil, gp.errorf(gt, "targets", "%v", err)
+		}
+		cfg.Groups = append(cfg.Groups, g)
+	}
+	return cfg, nil
+}
+
+// configParser reads the settings of one table.
+type configParser struct {
+	path string // dotted path of the table, for errors
+}
+
+func (p configParser) errorf(t *toml.Tree, key, format string, args ...any) error {
+	where := p.path + "." + key
+	if pos := t.GetPositionPath([]string{key}); !pos.Invalid() {
+		where += fmt.Sprintf(" (line %d)", pos.Line)
+	}
+	return fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...))
+}
+
+// group reads the settings of t into g. extra names the one key besides
+// the settings that t may hold.
+func (p configParser) group(t *toml.Tree, g *GroupConfig, extra string) error {
+	for _, key := range t.Keys() {
+		v := t.GetPath([]string{key})
+		var err error
+		switch key {
+		case "interval":
+			g.Interval, err = p.duration(t, key, v)
+		case "timeout":
+			g.Timeout, err = p.duration(t, key, v)
+		case "size":
+			g.Size, err = p.int(t, key, v, 0, maxConfigSize)
+		case "downAfter":
+			g.DownAfter, err = p.int(t, key, v, 1, 1<<16)
+		case "upAfter":
+			g.UpAfter, err = p.int(t, key, v, 1, 1<<16)
+		case "targets":
+			if key != extra {
+				return p.errorf(t, key, "targets belong in a group")
+			}
+			g.Targets, err = p.strings(t, key, v)
+		default:
+			if key != extra {
+				return p.errorf(t, key, "unknown setting")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p configParser) duration(t *toml.Tree, key string, v any) (time.Duration, error) {
+	var d time.Duration
+	switch v := v.(type) {
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return 0, p.errorf(t, key, "invalid duration %q", v)
+		}
+	case int64:
+		d = time.Duration(v) * time.Second
+	default:
+		return 0, p.errorf(t, key, "expected a duration such as \"10s\", got %v", v)
+	}
+	if d <= 0 {
+		return 0, p.errorf(t, key, "must be positive, got %v", d)
+	}
+	return d, nil
+}
+
+func (p configParser) int(t *toml.Tree, key string, v any, lo, hi int) (int, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, p.errorf(t, key, "expected an integer, got %v", v)
+	}
+	if n < int64(lo) || n > int64(hi) {
+		return 0, p.errorf(t, key, "must be between %d and %d, got %d", lo, hi, n)
+	}
+	return int(n), nil
+}
+
+func (p configParser) strings(t *toml.Tree, key string, v any) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, p.errorf(t, key, "expected a list of strings")
+	}
+	out := make([]string, 0, len(list))
+	for _, e := range list {
+		s, ok := e.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return nil, p.errorf(t, key, "expected a list of non-empty strings, got %v", e)
+		}
+		out = append(out, strings.TrimSpace(s))
+	}
+	return out, nil
+}
+
+// Probes monitors the target groups of a Config, one Monitor per group, and
+// follows configuration changes. The embedded Options are the template of
+// every Monitor; configure them before the first Apply.
+type Probes struct {
+	Options
+
+	// OnChange, when set, is called with every state change of a target.
+	// It runs on the goroutine of the group's Monitor.
+	OnChange func(group string, c StateChange)
+	// OnError, when set, is called with the errors met while reloading a
+	// watched configuration. The running groups are kept.
+	OnError func(error)
+
+	mu     sync.Mutex
+	groups map[string]*runningGroup
+}
+
+// runningGroup is a group whose Monitor runs.
+type runningGroup struct {
+	cfg GroupConfig
+	mon *Monitor
+}
+
+// NewProbes returns a Probes with default options and no groups.
+func NewProbes() *Probes {
+	return &Probes{}
+}
+
+// Apply makes the running groups match cfg: groups that were removed or
+// changed are stopped, and new or changed ones started. Unchanged groups
+// keep running with their state.
+func (p *Probes) Apply(cfg *Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	want := make(map[string]bool, len(cfg.Groups))
+	for _, g := range cfg.Groups {
+		want[g.Name] = true
+	}
+	for name, rg := range p.groups {
+		if !want[name] {
+			rg.mon.Stop()
+			delete(p.groups, name)
+		}
+	}
+
+	var errs []error
+	for _, g := range cfg.Groups {
+		if rg, ok := p.groups[g.Name]; ok {
+			if reflect.DeepEqual(rg.cfg, g) {
+				continue
+			}
+			rg.mon.Stop()
+			delete(p.groups, g.Name)
+		}
+		if err := p.start(g); err != nil {
+			errs = append(errs, fmt.Errorf("group %s: %w", g.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// start starts the Monitor of group g. p.mu must be held.
+func (p *Probes) start(g GroupConfig) error {
+	m := NewMonitor(g.Targets...)
+	m.Options = p.Options
+	m.Timeout = g.Timeout
+	m.Size = g.Size
+	m.Interval = g.Interval
+	m.DownAfter = g.DownAfter
+	m.UpAfter = g.UpAfter
+
+	events, err := m.Run()
+	if err != nil {
+		return err
+	}
+	if p.groups == nil {
+		p.groups = make(map[string]*runningGroup)
+	}
+	p.groups[g.Name] = &runningGroup{cfg: g, mon: m}
+	go func() {
+		for c := range events {
+			if p.OnChange != nil {
+				p.OnChange(g.Name, c)
+			}
+		}
+	}()
+	return nil
+}
+
+// Groups returns the configuration of the running groups, ordered by name.
+func (p *Probes) Groups() []GroupConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	groups := make([]GroupConfig, 0, len(p.groups))
+	for _, rg := range p.groups {
+		groups = append(groups, rg.cfg)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// State returns the current state of target in group.
+func (p *Probes) State(group, target string) State {
+	p.mu.Lock()
+	rg, ok := p.groups[group]
+	p.mu.Unlock()
+	if !ok {
+		return StateUnknown
+	}
+	return rg.mon.State(target)
+}
+
+// Stop stops every group.
+func (p *Probes) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, rg := range p.groups {
+		rg.mon.Stop()
+		delete(p.groups, name)
+	}
+}
+
+// Watch loads the configuration file at path, applies its [ping] table,
+// and then checks the file every interval, reloading it once a change has
+// stayed put for a full interval, until ctx is done. The file is parsed on
+// its own, leaving the loader configuration alone. An invalid initial
+// configuration is returned as an error; later ones are reported to
+// OnError and leave the running groups alone.
+func (p *Probes) Watch(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	stamp, err := fileStamp(path)
+	if err != nil {
+		return err
+	}
+	if err := p.reload(path); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// pending is the last stamp seen that differs from the applied
+		// one. A file being rewritten in place changes from tick to tick,
+		// so it is only reloaded once the same stamp is seen twice.
+		pending := stamp
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			next, err := fileStamp(path)
+			if err != nil {
+				p.reportError(err)
+				continue
+			}
+			if next == stamp || next != pending {
+				pending = next
+				continue
+			}
+			stamp = next
+			if err := p.reload(path); err != nil {
+				p.reportError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reload parses the configuration file at path and applies its [ping]
+// table.
+func (p *Probes) reload(path string) error {
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	t, ok := tree.Get(configTableName).(*toml.Tree)
+	if !ok {
+		return fmt.Errorf("%s: no [%s] table", path, configTableName)
+	}
+	cfg, err := ParseConfig(t)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return p.Apply(cfg)
+}
+
+func (p *Probes) reportError(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
+
+// configStamp identifies a version of a configuration file.
+type configStamp struct {
+	mod  time.Time
+	size int64
+}
+
+func fileStamp(path string) (configStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return configStamp{}, err
+	}
+	return configStamp{mod: fi.ModTime(), size: fi.Size()}, nil
+}
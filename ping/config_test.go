@@ -0,0 +1,182 @@
+package ping
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+func parseConfigString(t *testing.T, s string) (*Config, error) {
+	t.Helper()
+	tree, err := toml.Load(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, ok := tree.Get("ping").(*toml.Tree)
+	if !ok {
+		t.Fatal("no [ping] table")
+	}
+	return ParseConfig(table)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfigString(t, `
+[ping]
+interval = "30s"
+size = 100
+upAfter = 1
+
+[ping.groups.core]
+targets = ["192.0.2.1", "198.51.100.0/30"]
+interval = 5
+downAfter = 5
+
+[ping.groups.edge]
+targets = ["192.0.2.9"]
+timeout = "250ms"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Groups) != 2 {
+		t.Fatalf("expected 2 groups, got %+v", cfg.Groups)
+	}
+	core, edge := cfg.Groups[0], cfg.Groups[1]
+	if core.Name != "core" || len(core.Targets) != 2 || core.Interval != 5*time.Second || core.DownAfter != 5 ||
+		core.UpAfter != 1 || core.Size != 100 || core.Timeout != DefaultTimeout {
+		t.Errorf("unexpected core group %+v", core)
+	}
+	if edge.Name != "edge" || edge.Interval != 30*time.Second || edge.Timeout != 250*time.Millisecond || edge.DownAfter != DefaultDownAfter {
+		t.Errorf("unexpected edge group %+v", edge)
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	for _, c := range []struct {
+		config string
+		want   string
+	}{
+		{"[ping]\ninterval = \"1s\"\n", "ping.groups: no target groups defined"},
+		{"[ping]\nintervall = \"1s\"\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\n", "ping.intervall (line 2): unknown setting"},
+		{"[ping]\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\ninterval = \"soon\"\n", `ping.groups.a.interval (line 4): invalid duration "soon"`},
+		{"[ping]\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\ntimeout = \"-1s\"\n", "ping.groups.a.timeout (line 4): must be positive"},
+		{"[ping]\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\nsize = \"big\"\n", "ping.groups.a.size (line 4): expected an integer"},
+		{"[ping]\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\ndownAfter = 0\n", "ping.groups.a.downAfter (line 4): must be between 1"},
+		{"[ping]\n[ping.groups.a]\ninterval = \"1s\"\n", "ping.groups.a.targets: no targets"},
+		{"[ping]\n[ping.groups.a]\ntargets = [\"192.0.2.0/33\"]\n", "ping.groups.a.targets (line 3): invalid CIDR"},
+		{"[ping]\n[ping.groups.a]\ntargets = [1]\n", "ping.groups.a.targets (line 3): expected a list of non-empty strings"},
+		{"[ping]\ntargets = [\"192.0.2.1\"]\n[ping.groups.a]\ntargets = [\"192.0.2.1\"]\n", "ping.targets (line 2): targets belong in a group"},
+	} {
+		_, err := parseConfigString(t, c.config)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: expected error containing %q, got %v", c.config, c.want, err)
+		}
+	}
+}
+
+// fakeProbes returns a Probes whose groups probe over fake transports on
+// which every target answers.
+func fakeProbes() *Probes {
+	p := NewProbes()
+	p.Listen = listenEcho()
+	return p
+}
+
+func TestProbes_FakeApply(t *testing.T) {
+	p := fakeProbes()
+	defer p.Stop()
+	changes := make(chan string, 16)
+	p.OnChange = func(group string, c StateChange) { changes <- group + " " + c.Target + " " + c.To.String() }
+
+	group := func(name, target string) GroupConfig {
+		return GroupConfig{Name: name, Targets: []string{target}, Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond, DownAfter: 1, UpAfter: 1}
+	}
+	if err := p.Apply(&Config{Groups: []GroupConfig{group("a", "192.0.2.1"), group("b", "192.0.2.2")}}); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case c := <-changes:
+			got[c] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("targets did not come up: %v", got)
+		}
+	}
+	if !got["a 192.0.2.1 up"] || !got["b 192.0.2.2 up"] {
+		t.Errorf("unexpected changes %v", got)
+	}
+	mon := p.groups["a"].mon
+
+	// Drop b, keep a as is and add c.
+	if err := p.Apply(&Config{Groups: []GroupConfig{group("a", "192.0.2.1"), group("c", "192.0.2.3")}}); err != nil {
+		t.Fatal(err)
+	}
+	groups := p.Groups()
+	if len(groups) != 2 || groups[0].Name != "a" || groups[1].Name != "c" {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+	if p.groups["a"].mon != mon || p.State("a", "192.0.2.1") != StateUp {
+		t.Error("unchanged group was restarted")
+	}
+	if p.State("b", "192.0.2.2") != StateUnknown {
+		t.Error("removed group still reports state")
+	}
+}
+
+func TestProbes_FakeWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	write := func(targets string) {
+		t.Helper()
+		config := "[ping]\ninterval = \"10ms\"\ntimeout = \"50ms\"\n[ping.groups.a]\ntargets = [" + targets + "]\n"
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`"192.0.2.1"`)
+
+	p := fakeProbes()
+	defer p.Stop()
+	errs := make(chan error, 16)
+	p.OnError = func(err error) { errs <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Watch(ctx, path, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if g := p.Groups(); len(g) != 1 || len(g[0].Targets) != 1 {
+		t.Fatalf("unexpected groups %+v", g)
+	}
+
+	write(`"192.0.2.1", "192.0.2.2"`)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.Groups()[0].Targets) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("configuration change not applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// An invalid configuration is reported and leaves the groups alone.
+	write(`"192.0.2.0/99"`)
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "ping.groups.a.targets") {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("invalid configuration not reported")
+	}
+	if g := p.Groups(); len(g) != 1 || len(g[0].Targets) != 2 {
+		t.Errorf("groups changed after an invalid configuration: %+v", g)
+	}
+}
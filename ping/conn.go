@@ -0,0 +1,298 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// SocketType selects the kind of ICMP socket used for probing.
+type SocketType int
+
+const (
+	// SocketAuto tries a raw socket first and falls back to a datagram
+	// socket when the process lacks the privilege to open raw sockets.
+	SocketAuto SocketType = iota
+	// SocketRaw uses a raw ICMP socket; requires root or CAP_NET_RAW.
+	SocketRaw
+	// SocketDatagram uses an unprivileged ICMP datagram socket. On Linux
+	// the caller's group must be within net.ipv4.ping_group_range.
+	SocketDatagram
+)
+
+func (s SocketType) String() string {
+	switch s {
+	case SocketRaw:
+		return "raw"
+	case SocketDatagram:
+		return "datagram"
+	default:
+		return "auto"
+	}
+}
+
+// Conn is the transport that probes are exchanged over: the ICMP socket of
+// one address family by default, or whatever Options.Listen returns, such as
+// an in-memory fake in tests. Messages are ICMP messages without IP header.
+type Conn interface {
+	// ReadFrom reads one packet into b and returns the ICMP message within
+	// it together with what is known about the packet.
+	ReadFrom(b []byte) (msg []byte, info PacketInfo, err error)
+	// WriteTo sends the ICMP message b to dst.
+	WriteTo(b []byte, dst *net.IPAddr) (int, error)
+	// SetReadDeadline bounds pending and future reads, which then fail
+	// with an error wrapping os.ErrDeadlineExceeded.
+	SetReadDeadline(t time.Time) error
+	// EchoID returns the ID that replies to a request sent with ID want
+	// will carry. Transports that assign their own identifier, such as
+	// datagram sockets, return it instead.
+	EchoID(want uint16) uint16
+	Close() error
+}
+
+// PacketInfo describes a packet read from a Conn.
+type PacketInfo struct {
+	From net.IP    // sender's address
+	TTL  int       // TTL or hop limit; 0 when unknown
+	At   time.Time // kernel receive time; zero when unavailable
+}
+
+// Clock tells where the receive time of a reply, and hence its RTT, was
+// taken.
+type Clock int
+
+const (
+	// ClockNone is reported when no reply arrived.
+	ClockNone Clock = iota
+	// ClockKernel is the time the kernel received the packet
+	// (SO_TIMESTAMPNS).
+	ClockKernel
+	// ClockUser is the time the process read the packet. It is the
+	// fallback when the kernel gives no timestamp and also includes
+	// scheduling delays.
+	ClockUser
+)
+
+func (c Clock) String() string {
+	switch c {
+	case ClockKernel:
+		return "kernel"
+	case ClockUser:
+		return "user"
+	default:
+		return "none"
+	}
+}
+
+// received returns the receive time of the packet: the kernel timestamp
+// when there is one, the current time otherwise. Call it right after the
+// packet was read.
+func (i PacketInfo) received() (time.Time, Clock) {
+	if !i.At.IsZero() {
+		return i.At, ClockKernel
+	}
+	return time.Now(), ClockUser
+}
+
+// sockoptConn is implemented by transports that support the socket options
+// Traceroute and DiscoverMTU rely on.
+type sockoptConn interface {
+	setTTL(ttl int) error
+	setDontFragment() error
+}
+
+// errNoSocketOptions is returned when a transport lacks a socket option.
+var errNoSocketOptions = errors.New("socket options are not supported by this connection")
+
+// socketOptions returns conn as a sockoptConn when it supports socket options.
+func socketOptions(conn Conn) (sockoptConn, error) {
+	inner := conn
+	if rc, ok := conn.(*recordingConn); ok {
+		inner = rc.Conn
+	}
+	if _, ok := inner.(sockoptConn); !ok {
+		return nil, errNoSocketOptions
+	}
+	sc, ok := conn.(sockoptConn)
+	if !ok {
+		return nil, errNoSocketOptions
+	}
+	return sc, nil
+}
+
+// packetConn is an ICMP socket of one family in either raw or datagram
+// mode. It hides the differences in addressing, IP header handling and
+// echo ID assignment between the two.
+type packetConn struct {
+	c      net.PacketConn
+	family Family
+	typ    SocketType // SocketRaw or SocketDatagram, never SocketAuto
+	id     uint16     // kernel-assigned echo ID in datagram mode
+}
+
+// listen opens an ICMP socket for fam according to typ, bound to src unless
+// it is nil. The socket reports the TTL and, where supported, the kernel
+// receive time of packets.
+func listen(fam Family, typ SocketType, src net.IP) (*packetConn, error) {
+	conn, err := listenType(fam, typ, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.reportTTL(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Without kernel timestamps, receive times are taken in user space.
+	_ = conn.reportTimestamps()
+	return conn, nil
+}
+
+func listenType(fam Family, typ SocketType, src net.IP) (*packetConn, error) {
+	switch typ {
+	case SocketRaw:
+		return listenRaw(fam, src)
+	case SocketDatagram:
+		return listenDatagram(fam, src)
+	}
+
+	conn, err := listenRaw(fam, src)
+	if err == nil || !errors.Is(err, os.ErrPermission) {
+		return conn, err
+	}
+	conn, dgramErr := listenDatagram(fam, src)
+	if dgramErr != nil {
+		return nil, fmt.Errorf("%w (datagram fallback: %v)", err, dgramErr)
+	}
+	return conn, nil
+}
+
+func listenRaw(fam Family, src net.IP) (*packetConn, error) {
+	addr := fam.listenAddr()
+	if src != nil {
+		addr = src.String()
+	}
+	c, err := net.ListenPacket(fam.network(), addr)
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{c: c, family: fam, typ: SocketRaw}, nil
+}
+
+// apply sets the socket options requested by o on the socket.
+func (c *packetConn) apply(o *Options) error {
+	if o.Interface != "" {
+		if err := c.bindToDevice(o.Interface); err != nil {
+			return fmt.Errorf("bind to %s: %w", o.Interface, err)
+		}
+	}
+	if o.TTL > 0 {
+		if err := c.setTTL(o.TTL); err != nil {
+			return err
+		}
+	}
+	if o.TOS > 0 {
+		if err := c.setTOS(o.TOS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EchoID returns the ID that replies to this socket will carry. Datagram
+// sockets replace the ID of every request with their own identifier.
+func (c *packetConn) EchoID(want uint16) uint16 {
+	if c.typ == SocketDatagram {
+		return c.id
+	}
+	return want
+}
+
+// WriteTo sends the ICMP message b to dst.
+func (c *packetConn) WriteTo(b []byte, dst *net.IPAddr) (int, error) {
+	if c.typ == SocketDatagram {
+		return c.c.WriteTo(b, &net.UDPAddr{IP: dst.IP, Zone: dst.Zone})
+	}
+	return c.c.WriteTo(b, dst)
+}
+
+// ReadFrom reads one packet into b and returns the ICMP message within it
+// together with the sender's address, the TTL and the kernel receive time
+// when known.
+func (c *packetConn) ReadFrom(b []byte) (msg []byte, info PacketInfo, err error) {
+	var oob [128]byte
+	switch conn := c.c.(type) {
+	case *net.IPConn:
+		// ReadMsgIP keeps the IPv4 header, which carries the TTL. IPv6 raw
+		// sockets never deliver the IPv6 header; the hop limit arrives as a
+		// control message.
+		n, oobn, _, from, err := conn.ReadMsgIP(b, oob[:])
+		if err != nil {
+			return nil, PacketInfo{}, err
+		}
+		info.From = from.IP
+		info.TTL, info.At = parseControl(oob[:oobn])
+		if c.family == FamilyIPv4 {
+			if off, ttl := ipHeaderLen(b[:n]); off > 0 {
+				info.TTL = ttl
+				return b[off:n], info, nil
+			}
+		}
+		return b[:n], info, nil
+
+	case *net.UDPConn:
+		n, oobn, _, from, err := conn.ReadMsgUDP(b, oob[:])
+		if err != nil {
+			return nil, PacketInfo{}, err
+		}
+		info.From = from.IP
+		info.TTL, info.At = parseControl(oob[:oobn])
+		return b[:n], info, nil
+	}
+
+	n, from, err := c.c.ReadFrom(b)
+	if err != nil {
+		return nil, PacketInfo{}, err
+	}
+	return b[:n], PacketInfo{From: from.(*net.UDPAddr).IP}, nil
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	return c.c.SetReadDeadline(t)
+}
+
+// watch interrupts pending and future reads from c once ctx is done, by
+// moving the read deadline into the past. The returned function stops
+// watching.
+func watch(ctx context.Context, c Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		_ = c.SetReadDeadline(aLongTimeAgo)
+	})
+}
+
+// setReadDeadlineContext sets the read deadline of c to t without losing an
+// interruption by watch that raced with it.
+func setReadDeadlineContext(ctx context.Context, c Conn, t time.Time) {
+	_ = c.SetReadDeadline(t)
+	if ctx.Err() != nil {
+		_ = c.SetReadDeadline(aLongTimeAgo)
+	}
+}
+
+// aLongTimeAgo is a read deadline that makes reads fail immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// contextError returns the error of ctx when it is done, since that is the
+// reason a read was interrupted, and err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (c *packetConn) Close() error {
+	return c.c.Close()
+}
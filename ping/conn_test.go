@@ -0,0 +1,569 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// In-memory transport
+// ---------------------------------------------------------------------------
+
+// fakePacket is a message the fake delivers to readers after delay.
+type fakePacket struct {
+	msg   []byte
+	from  net.IP
+	ttl   int
+	delay time.Duration
+
+	// kernelLead, when set, makes the packet carry a kernel receive time
+	// that far before it is read.
+	kernelLead time.Duration
+}
+
+// fakeResponder computes the packets answering a request sent to dst with
+// the given TTL (0 when never set).
+type fakeResponder func(req []byte, dst net.IP, ttl int) []fakePacket
+
+// fakeConn is an in-memory Conn of one family. Every message written to it is
+// handed to respond, and the packets it returns can be read back.
+type fakeConn struct {
+	fam     Family
+	id      uint16 // substituted for the request ID like a datagram socket; 0 keeps it
+	respond fakeResponder
+
+	mu       sync.Mutex
+	queue    []fakePacket
+	deadline time.Time
+	wake     chan struct{} // closed and replaced whenever a reader should recheck
+	ttl      int
+	sent     int
+	closed   bool
+}
+
+func newFakeConn(fam Family, respond fakeResponder) *fakeConn {
+	return &fakeConn{fam: fam, respond: respond, wake: make(chan struct{})}
+}
+
+// listen is an Options.Listen function returning c.
+func (c *fakeConn) listen(fam Family) (Conn, error) {
+	if fam != c.fam {
+		return nil, fmt.Errorf("fake transport is %s, not %s", c.fam, fam)
+	}
+	return c, nil
+}
+
+// notify wakes blocked readers. c.mu must be held.
+func (c *fakeConn) notify() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+func (c *fakeConn) ReadFrom(b []byte) ([]byte, PacketInfo, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, PacketInfo{}, net.ErrClosed
+		}
+		if len(c.queue) > 0 {
+			p := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			n := copy(b, p.msg)
+			info := PacketInfo{From: p.from, TTL: p.ttl}
+			if p.kernelLead != 0 {
+				info.At = time.Now().Add(-p.kernelLead)
+			}
+			return b[:n], info, nil
+		}
+
+		var expire <-chan time.Time
+		var timer *time.Timer
+		if !c.deadline.IsZero() {
+			d := time.Until(c.deadline)
+			if d <= 0 {
+				c.mu.Unlock()
+				return nil, PacketInfo{}, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			expire = timer.C
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-expire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst *net.IPAddr) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	c.sent++
+	ttl := c.ttl
+	c.mu.Unlock()
+
+	req := append([]byte(nil), b...)
+	if c.id != 0 {
+		binary.BigEndian.PutUint16(req[4:6], c.id)
+		setChecksum(c.fam, req)
+	}
+	for _, p := range c.respond(req, dst.IP, ttl) {
+		if p.delay <= 0 {
+			c.deliver(p)
+			continue
+		}
+		p := p
+		time.AfterFunc(p.delay, func() { c.deliver(p) })
+	}
+	return len(b), nil
+}
+
+func (c *fakeConn) deliver(p fakePacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = append(c.queue, p)
+	c.notify()
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	c.notify()
+	return nil
+}
+
+func (c *fakeConn) EchoID(want uint16) uint16 {
+	if c.id != 0 {
+		return c.id
+	}
+	return want
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.notify()
+	return nil
+}
+
+func (c *fakeConn) setTTL(ttl int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	return nil
+}
+
+func (c *fakeConn) setDontFragment() error { return nil }
+
+// sentCount returns the number of messages written so far.
+func (c *fakeConn) sentCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// setChecksum recomputes the checksum of an ICMPv4 message. ICMPv6
+// checksums are left to the kernel, so the fake leaves them alone.
+func setChecksum(fam Family, msg []byte) {
+	if fam != FamilyIPv4 {
+		return
+	}
+	msg[2], msg[3] = 0, 0
+	binary.BigEndian.PutUint16(msg[2:4], ipChecksum(msg))
+}
+
+// echoFrom turns the echo request req into the reply sent back by from.
+func echoFrom(fam Family, req []byte, from net.IP, delay time.Duration) fakePacket {
+	msg := append([]byte(nil), req...)
+	msg[0] = fam.echoReply()
+	setChecksum(fam, msg)
+	return fakePacket{msg: msg, from: from, ttl: 64, delay: delay}
+}
+
+// errorFrom answers the IPv4 echo request req to dst with an ICMP error of
+// the given type and code sent by router.
+func errorFrom(typ, code uint8, req []byte, dst, router net.IP) fakePacket {
+	id := binary.BigEndian.Uint16(req[4:6])
+	seq := binary.BigEndian.Uint16(req[6:8])
+	msg := icmpError(typ, code, timeExceededV4(dst, id, seq))
+	setChecksum(FamilyIPv4, msg)
+	return fakePacket{msg: msg, from: router, ttl: 250}
+}
+
+// replyAfter answers every request with an echo reply after delay.
+func replyAfter(delay time.Duration) fakeResponder {
+	return func(req []byte, dst net.IP, _ int) []fakePacket {
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, delay)}
+	}
+}
+
+// listenEcho returns an Options.Listen that opens a fresh fake transport of
+// the requested family, on which the targets in alive answer every request
+// at once. Every target answers when alive is empty.
+func listenEcho(alive ...net.IP) func(Family) (Conn, error) {
+	return func(fam Family) (Conn, error) {
+		return newFakeConn(fam, func(req []byte, dst net.IP, _ int) []fakePacket {
+			if len(alive) > 0 && !slices.ContainsFunc(alive, dst.Equal) {
+				return nil
+			}
+			return []fakePacket{echoFrom(fam, req, dst, 0)}
+		}).listen(fam)
+	}
+}
+
+func seqOf(req []byte) uint16 {
+	return binary.BigEndian.Uint16(req[6:8])
+}
+
+// fakePinger returns a Pinger for target that runs count probes over conn.
+func fakePinger(target string, count int, conn *fakeConn) *Pinger {
+	p := NewPinger(target)
+	p.Count = count
+	p.Interval = time.Millisecond
+	p.Timeout = 100 * time.Millisecond
+	p.Listen = conn.listen
+	return p
+}
+
+// ---------------------------------------------------------------------------
+// Pinger over the fake transport
+// ---------------------------------------------------------------------------
+
+func TestPinger_FakeReplies(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, replyAfter(5*time.Millisecond))
+	stats, err := fakePinger("192.0.2.1", 3, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sent != 3 || stats.Received != 3 || stats.PacketLoss != 0 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	for i, r := range stats.Results {
+		if !r.Success || r.Seq != i+1 || r.TTL != 64 || r.RTT < 5*time.Millisecond {
+			t.Errorf("result %d: unexpected %+v", i, r)
+		}
+	}
+	if stats.MinRTT < 5*time.Millisecond {
+		t.Errorf("expected min RTT of at least 5ms, got %v", stats.MinRTT)
+	}
+}
+
+func TestPinger_FakeLiteral(t *testing.T) {
+	var ids []uint16
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		ids = append(ids, binary.BigEndian.Uint16(req[4:6]))
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	p := &Pinger{
+		Options:  Options{Timeout: 100 * time.Millisecond, Listen: conn.listen},
+		Target:   "192.0.2.1",
+		Count:    2,
+		Interval: time.Millisecond,
+	}
+	stats, err := p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 2 || len(ids) != 2 || ids[0] != ids[1] {
+		t.Errorf("unexpected statistics %+v with IDs %v", stats, ids)
+	}
+	p.Stop()
+
+	// Stop works on every zero value, before or without Run.
+	(&Pinger{}).Stop()
+	(&Sweeper{}).Stop()
+	(&Engine{}).Stop()
+	(&Monitor{}).Stop()
+	(&Probes{}).Stop()
+}
+
+func TestPinger_FakeTimeouts(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		if seqOf(req)%2 == 0 {
+			return nil
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	p := fakePinger("192.0.2.1", 4, conn)
+	p.Timeout = 20 * time.Millisecond
+	stats, err := p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 2 || stats.PacketLoss != 50 {
+		t.Fatalf("expected 2 of 4 replies, got %+v", stats)
+	}
+	for _, r := range stats.Results {
+		if r.Seq%2 == 0 && (r.Success || !errors.Is(r.Err, os.ErrDeadlineExceeded)) {
+			t.Errorf("seq %d: expected timeout, got %+v", r.Seq, r)
+		}
+	}
+}
+
+func TestPinger_FakeIgnoresStrayPackets(t *testing.T) {
+	other := net.ParseIP("192.0.2.99")
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		foreign := echoFrom(FamilyIPv4, req, dst, 0)
+		binary.BigEndian.PutUint16(foreign.msg[4:6], binary.BigEndian.Uint16(req[4:6])+1)
+		setChecksum(FamilyIPv4, foreign.msg)
+		return []fakePacket{
+			foreign,
+			echoFrom(FamilyIPv4, req, other, 0),
+			echoFrom(FamilyIPv4, req, dst, 0),
+			echoFrom(FamilyIPv4, req, dst, time.Millisecond), // duplicate
+		}
+	})
+	stats, err := fakePinger("192.0.2.1", 3, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sent != 3 || stats.Received != 3 || len(stats.Results) != 3 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
+
+func TestPinger_FakeICMPError(t *testing.T) {
+	router := net.ParseIP("198.51.100.1")
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		return []fakePacket{errorFrom(TypeDestinationUnreachable, 1, req, dst, router)}
+	})
+	stats, err := fakePinger("192.0.2.1", 2, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 0 || stats.PacketLoss != 100 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	for _, r := range stats.Results {
+		var ue *UnreachableError
+		if !errors.As(r.Err, &ue) || !ue.From.Equal(router) {
+			t.Errorf("seq %d: expected unreachable error from router, got %v", r.Seq, r.Err)
+		}
+	}
+}
+
+func TestPinger_FakeCorruptPayload(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		p := echoFrom(FamilyIPv4, req, dst, 0)
+		p.msg[len(p.msg)-1] ^= 0xFF
+		setChecksum(FamilyIPv4, p.msg)
+		return []fakePacket{p}
+	})
+	stats, err := fakePinger("192.0.2.1", 2, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Corrupted != 2 || stats.Received != 0 || stats.PacketLoss != 0 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// PingContext, Sweeper and Traceroute over the fake transport
+// ---------------------------------------------------------------------------
+
+func TestPingContext_FakeKernelID(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, replyAfter(0))
+	conn.id = 4242
+	r := PingContext(context.Background(), "192.0.2.1", &Options{Listen: conn.listen})
+	if !r.Success || r.Seq != 1 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+}
+
+func TestPingContext_FakeCancel(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func([]byte, net.IP, int) []fakePacket { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r := PingContext(ctx, "192.0.2.1", &Options{Timeout: 5 * time.Second, Listen: conn.listen})
+	if !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline error, got %v", r.Err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("cancellation did not interrupt the read")
+	}
+}
+
+func TestSweeper_Fake(t *testing.T) {
+	up := net.ParseIP("192.0.2.1")
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		if !dst.Equal(up) {
+			return nil
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	s := NewSweeper("192.0.2.0/30")
+	s.Timeout = 20 * time.Millisecond
+	s.Listen = conn.listen
+	results, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]Result)
+	for r := range results {
+		got[r.IP] = r
+	}
+	if len(got) != 2 || conn.sentCount() != 2 {
+		t.Fatalf("expected 2 probes, got %v", got)
+	}
+	if !got["192.0.2.1"].Success {
+		t.Errorf("192.0.2.1: expected success, got %v", got["192.0.2.1"].Err)
+	}
+	if r := got["192.0.2.2"]; r.Success || !errors.Is(r.Err, os.ErrDeadlineExceeded) {
+		t.Errorf("192.0.2.2: expected timeout, got %+v", r)
+	}
+}
+
+func TestTraceroute_Fake(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, ttl int) []fakePacket {
+		if ttl < 3 {
+			router := net.IPv4(10, 0, 0, byte(ttl))
+			return []fakePacket{errorFrom(TypeTimeExceeded, 0, req, dst, router)}
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	opts := &TraceOptions{Options: Options{Timeout: 20 * time.Millisecond, Listen: conn.listen}, Probes: 2}
+	tr, err := Traceroute("192.0.2.1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Reached || len(tr.Hops) != 3 {
+		t.Fatalf("expected the target at hop 3, got %+v", tr)
+	}
+	for i, hop := range tr.Hops {
+		want := fmt.Sprintf("10.0.0.%d", i+1)
+		if i == 2 {
+			want = "192.0.2.1"
+		}
+		for _, pr := range hop.Probes {
+			if pr.Err != nil || pr.From != want {
+				t.Errorf("hop %d: expected answer from %s, got %+v", hop.TTL, want, pr)
+			}
+		}
+	}
+}
+
+func TestPinger_FakeClock(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		p := echoFrom(FamilyIPv4, req, dst, 30*time.Millisecond)
+		if seqOf(req) == 1 {
+			p.kernelLead = 20 * time.Millisecond
+		}
+		return []fakePacket{p}
+	})
+	stats, err := fakePinger("192.0.2.1", 2, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel, user := stats.Results[0], stats.Results[1]
+	if kernel.Clock != ClockKernel || kernel.RTT >= 20*time.Millisecond {
+		t.Errorf("expected RTT from the kernel receive time, got %v (%v)", kernel.RTT, kernel.Clock)
+	}
+	if user.Clock != ClockUser || user.RTT < 30*time.Millisecond {
+		t.Errorf("expected user space RTT, got %v (%v)", user.RTT, user.Clock)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Reply classification
+// ---------------------------------------------------------------------------
+
+func TestPinger_FakeDuplicates(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0), echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	p := fakePinger("192.0.2.1", 3, conn)
+	p.Interval = 10 * time.Millisecond
+	var mu sync.Mutex
+	kinds := make(map[ReplyKind]int)
+	p.OnReply = func(r Result) {
+		mu.Lock()
+		kinds[r.Kind]++
+		mu.Unlock()
+	}
+	stats, err := p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The session ends once the last probe is answered, before its
+	// duplicate is read.
+	if stats.Received != 3 || stats.Duplicates != 2 || stats.PacketLoss != 0 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	if kinds[ReplyNormal] != 3 || kinds[ReplyDuplicate] != 2 {
+		t.Errorf("unexpected reply kinds %v", kinds)
+	}
+}
+
+func TestPinger_FakeReordered(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		delay := time.Duration(0)
+		if seqOf(req) == 1 {
+			delay = 30 * time.Millisecond
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, delay)}
+	})
+	stats, err := fakePinger("192.0.2.1", 2, conn).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 2 || stats.Reordered != 1 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	if stats.Results[0].Kind != ReplyReordered || stats.Results[1].Kind != ReplyNormal {
+		t.Errorf("unexpected kinds %v, %v", stats.Results[0].Kind, stats.Results[1].Kind)
+	}
+}
+
+func TestPinger_FakeLate(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		delay := 15 * time.Millisecond
+		if seqOf(req) == 1 {
+			delay = 40 * time.Millisecond
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, delay)}
+	})
+	p := fakePinger("192.0.2.1", 2, conn)
+	p.Interval = 30 * time.Millisecond
+	p.Timeout = 20 * time.Millisecond
+	stats, err := p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 1 || stats.Late != 1 || stats.PacketLoss != 50 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	late := stats.Results[0]
+	if late.Kind != ReplyLate || late.Success || !errors.Is(late.Err, os.ErrDeadlineExceeded) || late.RTT < 40*time.Millisecond {
+		t.Errorf("unexpected late result %+v", late)
+	}
+}
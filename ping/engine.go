@@ -0,0 +1,373 @@
+package ping
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// DefaultRate is the default global probe rate of an Engine, in packets per
+// second.
+const DefaultRate = 500
+
+// Engine probes a stream of targets at a bounded rate, for runs over
+// thousands of hosts that must not trip ICMP rate limiting on routers. Probes
+// go out through one socket, written by a single sender goroutine and read
+// by a single receiver goroutine, so an Engine probes one address family:
+// IPv6 when Family is FamilyIPv6, IPv4 otherwise. Configure the exported
+// fields before calling Run.
+type Engine struct {
+	Options
+	Rate        float64       // global probes per second; <= 0 means DefaultRate
+	Burst       int           // probes that may be sent back to back; <= 0 means 1
+	MinInterval time.Duration // minimum delay between two probes of one target
+	MaxInFlight int           // bound on outstanding probes; <= 0 means DefaultMaxInFlight
+
+	ctl runControl
+}
+
+// NewEngine returns an Engine with default settings.
+func NewEngine() *Engine {
+	return &Engine{
+		Options:     Options{Timeout: DefaultTimeout},
+		Rate:        DefaultRate,
+		Burst:       1,
+		MaxInFlight: DefaultMaxInFlight,
+	}
+}
+
+// Stop ends a running Engine. Targets not yet probed are skipped and results
+// for outstanding probes are discarded. It is safe to call Stop more than
+// once and from any goroutine.
+func (e *Engine) Stop() {
+	e.ctl.halt()
+}
+
+// Run opens the socket and starts probing the targets received on targets,
+// host names or addresses that may repeat, in the background. Results are
+// delivered on the returned channel as probes complete; the channel is
+// closed once targets is closed and every probe has been settled, or the
+// Engine was stopped.
+//
+// Memory stays bounded: when results are not consumed, the Engine stops
+// sending once MaxInFlight probes are unsettled, and it stops reading
+// targets once MaxInFlight of them wait for their MinInterval.
+func (e *Engine) Run(targets <-chan string) (<-chan Result, error) {
+	return e.RunContext(context.Background(), targets)
+}
+
+// RunContext is like Run but also stops the Engine, as Stop does, when ctx
+// is done. Host names are resolved with ctx as well.
+func (e *Engine) RunContext(ctx context.Context, targets <-chan string) (<-chan Result, error) {
+	if e.Timeout < 0 {
+		return nil, errors.New("timeout must not be negative")
+	}
+	if e.MinInterval < 0 {
+		return nil, errors.New("minimum interval must not be negative")
+	}
+	limit := e.MaxInFlight
+	if limit <= 0 {
+		limit = DefaultMaxInFlight
+	}
+	// Pending probes are told apart by sequence number.
+	limit = min(limit, math.MaxUint16)
+
+	fam := FamilyIPv4
+	if e.Family == FamilyIPv6 {
+		fam = FamilyIPv6
+	}
+	conn, err := e.listen(fam)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+
+	out := make(chan Result, limit)
+	go e.run(ctx, conn, fam, targets, limit, out)
+	return out, nil
+}
+
+func (e *Engine) run(ctx context.Context, conn Conn, fam Family, targets <-chan string, limit int, out chan<- Result) {
+	defer close(out)
+
+	probes := make(chan *sweepProbe)
+	replies := make(chan echoReply)
+	done := make(chan struct{})
+	slots := make(chan struct{}, limit)
+	id := conn.EchoID(e.ctl.echoID())
+
+	go receiveEcho(conn, fam, id, nil, replies, done)
+	go e.send(ctx, conn, fam, id, targets, limit, probes, slots, done)
+
+	open := collect(ctx, e.ctl.stopped(), probes, replies, slots, out)
+
+	// Let the sender finish before the socket is closed.
+	close(done)
+	if open {
+		for range probes {
+		}
+	}
+	conn.Close()
+}
+
+// send paces the targets through the token bucket and the per-target
+// interval, and registers every probe with the collector before it is
+// written to the wire.
+func (e *Engine) send(ctx context.Context, conn Conn, fam Family, id uint16, targets <-chan string, limit int, probes chan<- *sweepProbe, slots chan struct{}, done <-chan struct{}) {
+	defer close(probes)
+
+	opts := e.Options
+	opts.Family = fam
+	bucket := newTokenBucket(e.Rate, e.Burst)
+	sched := newTargetSchedule(e.MinInterval)
+	var waiting delayQueue
+	var seq uint16
+
+	register := func(pr *sweepProbe) bool {
+		select {
+		case probes <- pr:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	sleep := func(d time.Duration) bool {
+		if d <= 0 {
+			return true
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for targets != nil || waiting.Len() > 0 {
+		var next delayedTarget
+		var ready bool
+		var wake <-chan time.Time
+		var timer *time.Timer
+		if waiting.Len() > 0 {
+			if d := time.Until(waiting[0].at); d <= 0 {
+				next, ready = heap.Pop(&waiting).(delayedTarget), true
+			} else {
+				timer = time.NewTimer(d)
+				wake = timer.C
+			}
+		}
+
+		if !ready {
+			in := targets
+			if waiting.Len() >= limit {
+				in = nil // back-pressure until waiting targets are due
+			}
+			select {
+			case name, ok := <-in:
+				if !ok {
+					targets = nil
+					break
+				}
+				addr, _, err := opts.resolve(ctx, name)
+				if err != nil {
+					if !slot(slots, done) || !register(&sweepProbe{failed: true, result: Result{IP: name, Err: err}}) {
+						return
+					}
+					break
+				}
+				next, ready = delayedTarget{name: name, addr: addr}, true
+			case <-wake:
+			case <-done:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !ready {
+			continue
+		}
+
+		// Keep the per-target interval, queueing the target if needed.
+		now := time.Now()
+		if at := sched.due(next.addr.IP, now, next.queued); at.After(now) {
+			next.at, next.queued = at, true
+			heap.Push(&waiting, next)
+			continue
+		}
+
+		if !sleep(bucket.take(time.Now())) || !slot(slots, done) {
+			return
+		}
+
+		seq++
+		now = time.Now()
+		sched.sent(next.addr.IP, now)
+		pr := &sweepProbe{
+			seq:      seq,
+			addr:     next.addr.IP,
+			sent:     now,
+			deadline: now.Add(e.timeout()),
+			payload:  e.payload(now),
+			result:   Result{IP: next.name, Seq: int(seq)},
+		}
+		if !register(pr) {
+			return
+		}
+
+		var src net.IP
+		if fam == FamilyIPv6 {
+			src = e.source(next.addr.IP)
+		}
+		if _, err := conn.WriteTo(buildEcho(fam, id, seq, pr.payload, src, next.addr.IP), next.addr); err != nil {
+			if !register(&sweepProbe{
+				seq:        seq,
+				failed:     true,
+				registered: true,
+				result:     Result{IP: next.name, Seq: int(seq), Err: fmt.Errorf("send: %w", err)},
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// slot takes one of the in-flight slots, waiting for the collector to
+// release one. It returns false when done is closed first.
+func slot(slots chan<- struct{}, done <-chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// tokenBucket limits a rate of events while allowing bursts.
+type tokenBucket struct {
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take consumes a token and returns how long to wait until it is actually
+// available.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// targetSchedule enforces the minimum interval between probes of a target.
+type targetSchedule struct {
+	interval time.Duration
+	targets  map[string]*targetTimes
+	sends    int
+}
+
+// targetTimes records when a target was last probed and the due time of the
+// latest probe of it waiting in the queue.
+type targetTimes struct {
+	sent, queued time.Time
+}
+
+func newTargetSchedule(interval time.Duration) *targetSchedule {
+	return &targetSchedule{interval: interval, targets: make(map[string]*targetTimes)}
+}
+
+// due returns when a probe of ip may be sent, not before now. A new probe
+// also waits for the probes of ip already queued and takes its place after
+// them; a queued probe only waits for the last one sent.
+func (s *targetSchedule) due(ip net.IP, now time.Time, queued bool) time.Time {
+	if s.interval <= 0 {
+		return now
+	}
+	t := s.targets[string(ip.To16())]
+	if t == nil {
+		return now
+	}
+	at := t.sent.Add(s.interval)
+	if !queued && !t.queued.IsZero() {
+		if q := t.queued.Add(s.interval); q.After(at) {
+			at = q
+		}
+	}
+	if !at.After(now) {
+		return now
+	}
+	if !queued {
+		t.queued = at
+	}
+	return at
+}
+
+// sent records a probe of ip at now.
+func (s *targetSchedule) sent(ip net.IP, now time.Time) {
+	if s.interval <= 0 {
+		return
+	}
+	key := string(ip.To16())
+	t := s.targets[key]
+	if t == nil {
+		t = &targetTimes{}
+		s.targets[key] = t
+	}
+	t.sent = now
+
+	// Forget targets whose interval has passed now and then, so that
+	// long runs over many targets do not accumulate them.
+	if s.sends++; s.sends%1024 == 0 {
+		for k, t := range s.targets {
+			if !t.sent.Add(s.interval).After(now) && !t.queued.After(now) {
+				delete(s.targets, k)
+			}
+		}
+	}
+}
+
+// delayedTarget is a resolved target, possibly waiting for its interval.
+type delayedTarget struct {
+	name   string
+	addr   *net.IPAddr
+	at     time.Time
+	queued bool
+}
+
+// delayQueue is a min-heap of delayed targets ordered by due time.
+type delayQueue []delayedTarget
+
+func (q delayQueue) Len() int           { return len(q) }
+func (q delayQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q delayQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *delayQueue) Push(x any)        { *q = append(*q, x.(delayedTarget)) }
+func (q *delayQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
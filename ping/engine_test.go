@@ -0,0 +1,204 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := time.Unix(0, 0)
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := b.take(now); got != want {
+			t.Errorf("take %d: expected wait %v, got %v", i, want, got)
+		}
+	}
+	// After a second the bucket is full again, but not fuller.
+	now = now.Add(time.Second)
+	if got := b.take(now); got != 0 {
+		t.Errorf("expected no wait after refill, got %v", got)
+	}
+}
+
+func TestTargetSchedule(t *testing.T) {
+	s := newTargetSchedule(100 * time.Millisecond)
+	ip := net.ParseIP("192.0.2.1")
+	t0 := time.Unix(0, 0)
+
+	if at := s.due(ip, t0, false); !at.Equal(t0) {
+		t.Fatalf("first probe should go now, got %v", at)
+	}
+	s.sent(ip, t0)
+	if at := s.due(ip, t0.Add(10*time.Millisecond), false); !at.Equal(t0.Add(100 * time.Millisecond)) {
+		t.Errorf("expected the second probe at 100ms, got %v", at.Sub(t0))
+	}
+	if at := s.due(ip, t0.Add(20*time.Millisecond), false); !at.Equal(t0.Add(200 * time.Millisecond)) {
+		t.Errorf("expected the third probe after the queued one, got %v", at.Sub(t0))
+	}
+	if at := s.due(ip, t0.Add(100*time.Millisecond), true); !at.Equal(t0.Add(100 * time.Millisecond)) {
+		t.Errorf("queued probe should go when due, got %v", at.Sub(t0))
+	}
+	if at := s.due(net.ParseIP("192.0.2.2"), t0, false); !at.Equal(t0) {
+		t.Errorf("other targets should not wait, got %v", at.Sub(t0))
+	}
+}
+
+// feed returns a closed channel holding targets.
+func feed(targets ...string) <-chan string {
+	c := make(chan string, len(targets))
+	for _, t := range targets {
+		c <- t
+	}
+	close(c)
+	return c
+}
+
+func TestEngine_FakeRate(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, replyAfter(0))
+	e := NewEngine()
+	e.Listen = conn.listen
+	e.Rate = 200
+	var targets []string
+	for i := 1; i <= 21; i++ {
+		targets = append(targets, net.IPv4(192, 0, 2, byte(i)).String())
+	}
+
+	start := time.Now()
+	results, err := e.Run(feed(targets...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r := range results {
+		if !r.Success {
+			t.Errorf("%s: %v", r.IP, r.Err)
+		}
+		n++
+	}
+	if n != len(targets) {
+		t.Errorf("expected %d results, got %d", len(targets), n)
+	}
+	// 21 probes at 200/s take at least 100ms after the first one.
+	if d := time.Since(start); d < 95*time.Millisecond {
+		t.Errorf("probes were not rate limited: %v", d)
+	}
+}
+
+func TestEngine_FakeMinInterval(t *testing.T) {
+	var mu sync.Mutex
+	var sent []time.Time
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		mu.Lock()
+		sent = append(sent, time.Now())
+		mu.Unlock()
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	e := NewEngine()
+	e.Listen = conn.listen
+	e.MinInterval = 30 * time.Millisecond
+	results, err := e.Run(feed("192.0.2.1", "192.0.2.1", "192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range results {
+		if !r.Success {
+			t.Errorf("seq %d: %v", r.Seq, r.Err)
+		}
+	}
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 probes, got %d", len(sent))
+	}
+	for i := 1; i < len(sent); i++ {
+		if d := sent[i].Sub(sent[i-1]); d < 29*time.Millisecond {
+			t.Errorf("probes %d and %d only %v apart", i, i+1, d)
+		}
+	}
+}
+
+func TestEngine_FakeBackPressure(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, replyAfter(0))
+	e := NewEngine()
+	e.Listen = conn.listen
+	e.Rate = 10000
+	e.MaxInFlight = 2
+	var targets []string
+	for i := 1; i <= 20; i++ {
+		targets = append(targets, net.IPv4(192, 0, 2, byte(i)).String())
+	}
+	results, err := e.Run(feed(targets...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the results: the engine must stall.
+	time.Sleep(50 * time.Millisecond)
+	if n := conn.sentCount(); n > 6 {
+		t.Errorf("expected the engine to stall, sent %d probes", n)
+	}
+
+	n := 0
+	for range results {
+		n++
+	}
+	if n != len(targets) {
+		t.Errorf("expected %d results, got %d", len(targets), n)
+	}
+}
+
+func TestEngine_StopClosesResults(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func([]byte, net.IP, int) []fakePacket { return nil })
+	e := NewEngine()
+	e.Listen = conn.listen
+	targets := make(chan string)
+	results, err := e.Run(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets <- "192.0.2.1"
+	e.Stop()
+	select {
+	case <-waitClosed(results):
+	case <-time.After(time.Second):
+		t.Fatal("results not closed after Stop")
+	}
+}
+
+// waitClosed drains results and reports when the channel is closed.
+func waitClosed(results <-chan Result) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range results {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func TestEngine_FakeStopUnread(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, replyAfter(0))
+	e := NewEngine()
+	e.Listen = conn.listen
+	e.Rate = 10000
+	e.MaxInFlight = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := e.RunContext(ctx, feed("192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the results, so the engine blocks delivering them until
+	// ctx is cancelled.
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for !conn.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("engine did not stop while blocked on its results")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	<-waitClosed(results)
+}
@@ -0,0 +1,392 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// RFC 4884 constants. ICMP error messages carrying extensions quote at
+// least 128 octets of the original datagram, padded to 32-bit words in ICMP
+// and 64-bit words in ICMPv6, and declare that length in their header.
+const (
+	extensionVersion     = 2
+	extensionMinOriginal = 128
+)
+
+// Extension object classes.
+const (
+	classMPLSLabelStack = 1 // RFC 4950
+	classInterfaceInfo  = 2 // RFC 5837
+)
+
+// Extension is an RFC 4884 extension object appended to an ICMP
+// destination unreachable, time exceeded or parameter problem message.
+type Extension interface {
+	// Len returns the length of the marshalled object, header included.
+	Len() int
+	// Marshal returns the wire format of the object.
+	Marshal() ([]byte, error)
+}
+
+// objectHeader returns the header of an extension object of length n.
+func objectHeader(n int, class, ctype uint8) []byte {
+	b := make([]byte, 4, n)
+	binary.BigEndian.PutUint16(b[0:2], uint16(n))
+	b[2], b[3] = class, ctype
+	return b
+}
+
+// MPLSLabel is an entry of an MPLS label stack.
+type MPLSLabel struct {
+	Label uint32 // 20 bits
+	TC    uint8  // traffic class, 3 bits
+	S     bool   // bottom of stack
+	TTL   uint8
+}
+
+// MPLSLabelStack is the MPLS label stack of the packet that triggered the
+// message, as received by the router (RFC 4950).
+type MPLSLabelStack struct {
+	Labels []MPLSLabel
+}
+
+// Len implements Extension.
+func (s *MPLSLabelStack) Len() int { return 4 + 4*len(s.Labels) }
+
+// Marshal implements Extension.
+func (s *MPLSLabelStack) Marshal() ([]byte, error) {
+	b := objectHeader(s.Len(), classMPLSLabelStack, 1)
+	for _, l := range s.Labels {
+		if l.Label > 0xfffff || l.TC > 7 {
+			return nil, fmt.Errorf("invalid MPLS label %d, traffic class %d", l.Label, l.TC)
+		}
+		v := l.Label<<12 | uint32(l.TC)<<9 | uint32(l.TTL)
+		if l.S {
+			v |= 1 << 8
+		}
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b, nil
+}
+
+func parseMPLSLabelStack(b []byte) (*MPLSLabelStack, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("malformed MPLS label stack")
+	}
+	s := &MPLSLabelStack{}
+	for ; len(b) > 0; b = b[4:] {
+		v := binary.BigEndian.Uint32(b)
+		s.Labels = append(s.Labels, MPLSLabel{Label: v >> 12, TC: uint8(v>>9) & 7, S: v&(1<<8) != 0, TTL: uint8(v)})
+	}
+	return s, nil
+}
+
+// InterfaceRole tells which interface an InterfaceInfo describes.
+type InterfaceRole uint8
+
+const (
+	// RoleIncoming is the interface the packet arrived on.
+	RoleIncoming InterfaceRole = iota
+	// RoleSubIP is the sub-IP component of the incoming interface.
+	RoleSubIP
+	// RoleOutgoing is the interface the packet would have left on.
+	RoleOutgoing
+	// RoleNextHop is the next hop the packet would have been sent to.
+	RoleNextHop
+)
+
+// Interface information object C-Type bits (RFC 5837).
+const (
+	ifInfoIndex = 1 << 3
+	ifInfoAddr  = 1 << 2
+	ifInfoName  = 1 << 1
+	ifInfoMTU   = 1 << 0
+)
+
+// maxInterfaceName is the longest name an InterfaceInfo can carry.
+const maxInterfaceName = 63
+
+// InterfaceInfo identifies an interface of the router that sent the message
+// (RFC 5837). Zero fields are absent from the object.
+type InterfaceInfo struct {
+	Role  InterfaceRole
+	Index uint32 // ifIndex
+	Addr  net.IP
+	Name  string // at most 63 bytes
+	MTU   uint32
+}
+
+// Len implements Extension.
+func (i *InterfaceInfo) Len() int {
+	n := 4
+	if i.Index != 0 {
+		n += 4
+	}
+	if i.Addr != nil {
+		n += 4 + len(addrBytes(i.Addr))
+	}
+	if i.Name != "" {
+		n += nameLen(i.Name)
+	}
+	if i.MTU != 0 {
+		n += 4
+	}
+	return n
+}
+
+// addrBytes returns ip in its shortest form.
+func addrBytes(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// nameLen returns the length of the name sub-object carrying name: a length
+// octet, then the name padded to 32-bit words.
+func nameLen(name string) int {
+	return (1 + len(name) + 3) &^ 3
+}
+
+// Marshal implements Extension.
+func (i *InterfaceInfo) Marshal() ([]byte, error) {
+	if i.Role > RoleNextHop {
+		return nil, fmt.Errorf("invalid interface role %d", i.Role)
+	}
+	if len(i.Name) > maxInterfaceName {
+		return nil, fmt.Errorf("interface name longer than %d bytes", maxInterfaceName)
+	}
+	ctype := uint8(i.Role) << 6
+	if i.Index != 0 {
+		ctype |= ifInfoIndex
+	}
+	if i.Addr != nil {
+		if len(addrBytes(i.Addr)) == 0 {
+			return nil, fmt.Errorf("invalid interface address %v", i.Addr)
+		}
+		ctype |= ifInfoAddr
+	}
+	if i.Name != "" {
+		ctype |= ifInfoName
+	}
+	if i.MTU != 0 {
+		ctype |= ifInfoMTU
+	}
+
+	b := objectHeader(i.Len(), classInterfaceInfo, ctype)
+	if i.Index != 0 {
+		b = binary.BigEndian.AppendUint32(b, i.Index)
+	}
+	if i.Addr != nil {
+		addr := addrBytes(i.Addr)
+		afi := uint16(1)
+		if len(addr) == net.IPv6len {
+			afi = 2
+		}
+		b = binary.BigEndian.AppendUint16(b, afi)
+		b = append(b, 0, 0)
+		b = append(b, addr...)
+	}
+	if i.Name != "" {
+		name := make([]byte, nameLen(i.Name))
+		name[0] = byte(len(name))
+		copy(name[1:], i.Name)
+		b = append(b, name...)
+	}
+	if i.MTU != 0 {
+		b = binary.BigEndian.AppendUint32(b, i.MTU)
+	}
+	return b, nil
+}
+
+func parseInterfaceInfo(ctype uint8, b []byte) (*InterfaceInfo, error) {
+	errMalformed := errors.New("malformed interface information object")
+	i := &InterfaceInfo{Role: InterfaceRole(ctype >> 6)}
+	if ctype&ifInfoIndex != 0 {
+		if len(b) < 4 {
+			return nil, errMalformed
+		}
+		i.Index, b = binary.BigEndian.Uint32(b), b[4:]
+	}
+	if ctype&ifInfoAddr != 0 {
+		if len(b) < 4 {
+			return nil, errMalformed
+		}
+		n := 0
+		switch binary.BigEndian.Uint16(b) {
+		case 1:
+			n = net.IPv4len
+		case 2:
+			n = net.IPv6len
+		default:
+			return nil, errMalformed
+		}
+		if len(b) < 4+n {
+			return nil, errMalformed
+		}
+		i.Addr, b = net.IP(append([]byte(nil), b[4:4+n]...)), b[4+n:]
+	}
+	if ctype&ifInfoName != 0 {
+		if len(b) < 1 || int(b[0]) < 1 || int(b[0]) > len(b) {
+			return nil, errMalformed
+		}
+		name := b[1:b[0]]
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		i.Name, b = string(name), b[b[0]:]
+	}
+	if ctype&ifInfoMTU != 0 {
+		if len(b) < 4 {
+			return nil, errMalformed
+		}
+		i.MTU = binary.BigEndian.Uint32(b)
+	}
+	return i, nil
+}
+
+// RawExtension is an extension object of a class without a dedicated type.
+type RawExtension struct {
+	Class uint8
+	Type  uint8 // C-Type
+	Data  []byte
+}
+
+// Len implements Extension.
+func (r *RawExtension) Len() int { return 4 + len(r.Data) }
+
+// Marshal implements Extension.
+func (r *RawExtension) Marshal() ([]byte, error) {
+	if r.Len() > 0xffff {
+		return nil, errors.New("extension object too long")
+	}
+	return append(objectHeader(r.Len(), r.Class, r.Type), r.Data...), nil
+}
+
+// originalLen returns the length the original datagram data is padded to
+// when extensions follow it.
+func originalLen(fam Family, data []byte) int {
+	unit := 4
+	if fam == FamilyIPv6 {
+		unit = 8
+	}
+	n := max(len(data), extensionMinOriginal)
+	return (n + unit - 1) / unit * unit
+}
+
+// errorBodyLen returns the length of an error message body quoting data and
+// carrying exts.
+func errorBodyLen(fam Family, data []byte, exts []Extension) int {
+	if len(exts) == 0 {
+		return 4 + len(data)
+	}
+	n := 4 + originalLen(fam, data) + 4
+	for _, e := range exts {
+		n += e.Len()
+	}
+	return n
+}
+
+// marshalErrorBody returns the body of an error message quoting data and
+// carrying exts, with the rest of the header left for the caller to fill in
+// apart from the RFC 4884 length.
+func marshalErrorBody(fam Family, data []byte, exts []Extension) ([]byte, error) {
+	b := make([]byte, 4, errorBodyLen(fam, data, exts))
+	if len(exts) == 0 {
+		return append(b, data...), nil
+	}
+
+	n := originalLen(fam, data)
+	if fam == FamilyIPv6 {
+		if n/8 > 0xff {
+			return nil, errors.New("original datagram too long for extensions")
+		}
+		b[0] = byte(n / 8)
+	} else {
+		if n/4 > 0xff {
+			return nil, errors.New("original datagram too long for extensions")
+		}
+		b[1] = byte(n / 4)
+	}
+	b = append(b, data...)
+	b = append(b, make([]byte, n-len(data))...)
+
+	ext := []byte{extensionVersion << 4, 0, 0, 0}
+	for _, e := range exts {
+		o, err := e.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		ext = append(ext, o...)
+	}
+	binary.BigEndian.PutUint16(ext[2:4], ipChecksum(ext))
+	return append(b, ext...), nil
+}
+
+// parseErrorBody splits the body of an error message into the quoted
+// original datagram and its extensions. Following RFC 4884 section 5, a
+// message from a router that predates the length field is also checked for
+// extensions right after 128 octets of original datagram.
+func parseErrorBody(fam Family, b []byte) (data []byte, exts []Extension, err error) {
+	if len(b) < 4 {
+		return nil, nil, errShortBody
+	}
+	rest := b[4:]
+	n := int(b[1]) * 4
+	if fam == FamilyIPv6 {
+		n = int(b[0]) * 8
+	}
+
+	switch {
+	case n > 0 && n < len(rest):
+		exts, err = parseExtensions(rest[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		rest = rest[:n]
+	case n == 0 && len(rest) > extensionMinOriginal:
+		if e, err := parseExtensions(rest[extensionMinOriginal:]); err == nil {
+			exts, rest = e, rest[:extensionMinOriginal]
+		}
+	}
+	return append([]byte(nil), rest...), exts, nil
+}
+
+// parseExtensions parses an RFC 4884 extension structure.
+func parseExtensions(b []byte) ([]Extension, error) {
+	if len(b) < 4 || b[0]>>4 != extensionVersion {
+		return nil, errors.New("not an extension structure")
+	}
+	if ipChecksum(b) != 0 {
+		return nil, errors.New("bad extension structure checksum")
+	}
+	var exts []Extension
+	for b = b[4:]; len(b) > 0; {
+		if len(b) < 4 {
+			return nil, errors.New("truncated extension object")
+		}
+		n := int(binary.BigEndian.Uint16(b[0:2]))
+		if n < 4 || n > len(b) {
+			return nil, fmt.Errorf("invalid extension object length %d", n)
+		}
+		class, ctype, payload := b[2], b[3], b[4:n]
+		var e Extension
+		var err error
+		switch {
+		case class == classMPLSLabelStack && ctype == 1:
+			e, err = parseMPLSLabelStack(payload)
+		case class == classInterfaceInfo:
+			e, err = parseInterfaceInfo(ctype, payload)
+		default:
+			e = &RawExtension{Class: class, Type: ctype, Data: append([]byte(nil), payload...)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, e)
+		b = b[n:]
+	}
+	return exts, nil
+}
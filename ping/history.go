@@ -0,0 +1,372 @@
+package ping
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default capacities of a History, per target.
+const (
+	DefaultHistorySamples = 3600    // an hour of probes every second
+	DefaultHistoryMinutes = 24 * 60 // a day of minute rollups
+	DefaultHistoryHours   = 7 * 24  // a week of hour rollups
+)
+
+// Sample is one probe outcome kept by a History.
+type Sample struct {
+	At      time.Time
+	Success bool
+	RTT     time.Duration
+}
+
+// Rollup summarises the probes of a target over one minute or one hour.
+type Rollup struct {
+	Start    time.Time // start of the period, aligned to its length
+	Sent     int
+	Received int
+	Loss     float64 // lost share of Sent, from 0 to 1
+	MinRTT   time.Duration
+	AvgRTT   time.Duration
+	MaxRTT   time.Duration
+}
+
+// add counts one probe outcome in r.
+func (r *Rollup) add(success bool, rtt time.Duration) {
+	r.Sent++
+	if success {
+		r.include(1, rtt, rtt, rtt)
+	}
+	r.Loss = float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+// merge counts the probes of o in r.
+func (r *Rollup) merge(o Rollup) {
+	r.Sent += o.Sent
+	if o.Received > 0 {
+		r.include(o.Received, o.MinRTT, o.AvgRTT, o.MaxRTT)
+	}
+	if r.Sent > 0 {
+		r.Loss = float64(r.Sent-r.Received) / float64(r.Sent)
+	}
+}
+
+// include adds n replies with the given RTT figures.
+func (r *Rollup) include(n int, lo, avg, hi time.Duration) {
+	if r.Received == 0 || lo < r.MinRTT {
+		r.MinRTT = lo
+	}
+	if hi > r.MaxRTT {
+		r.MaxRTT = hi
+	}
+	sum := float64(r.AvgRTT)*float64(r.Received) + float64(avg)*float64(n)
+	r.Received += n
+	r.AvgRTT = time.Duration(sum / float64(r.Received))
+}
+
+// History keeps recent probe results per target in fixed-size ring buffers
+// and downsamples them into one-minute and one-hour rollups, so that memory
+// stays bounded however long it runs. Results are expected in roughly
+// chronological order per target: a result older than the current minute of
+// its target is kept as a sample but left out of the rollups. Set the
+// capacities before the first Add.
+type History struct {
+	Samples int // raw samples per target; <= 0 means DefaultHistorySamples
+	Minutes int // minute rollups per target; <= 0 means DefaultHistoryMinutes
+	Hours   int // hour rollups per target; <= 0 means DefaultHistoryHours
+
+	path string // file saved by Close
+
+	mu      sync.Mutex
+	targets map[string]*series
+}
+
+// series is the history of one target. The current minute and hour are
+// open rollups, moved to their rings once a later result arrives.
+type series struct {
+	samples *ring[Sample]
+	minutes *ring[Rollup]
+	hours   *ring[Rollup]
+	minute  Rollup
+	hour    Rollup
+}
+
+// NewHistory returns an empty History with default capacities.
+func NewHistory() *History {
+	return &History{
+		Samples: DefaultHistorySamples,
+		Minutes: DefaultHistoryMinutes,
+		Hours:   DefaultHistoryHours,
+		targets: make(map[string]*series),
+	}
+}
+
+// OpenHistory returns a History holding the results saved at path, or an
+// empty one when the file does not exist. Close saves it back to path.
+func OpenHistory(path string) (*History, error) {
+	h := NewHistory()
+	h.path = path
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := h.Load(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Close saves the history to the file it was opened from, replacing it
+// atomically. It does nothing for a History not made by OpenHistory.
+func (h *History) Close() error {
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := h.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), h.path)
+}
+
+// series returns the series of target, creating it. h.mu must be held.
+func (h *History) series(target string) *series {
+	s, ok := h.targets[target]
+	if !ok {
+		s = &series{
+			samples: newRing[Sample](h.Samples, DefaultHistorySamples),
+			minutes: newRing[Rollup](h.Minutes, DefaultHistoryMinutes),
+			hours:   newRing[Rollup](h.Hours, DefaultHistoryHours),
+		}
+		h.targets[target] = s
+	}
+	return s
+}
+
+// Add records the result of a probe of r.IP made at at. It can serve as a
+// Monitor's OnResult hook through a closure adding time.Now().
+func (h *History) Add(at time.Time, r Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.series(r.IP)
+	s.samples.push(Sample{At: at, Success: r.Success, RTT: r.RTT})
+
+	minute := at.Truncate(time.Minute)
+	switch {
+	case s.minute.Start.IsZero():
+		s.minute.Start = minute
+	case minute.After(s.minute.Start):
+		s.closeMinute(minute)
+	case minute.Before(s.minute.Start):
+		return // too old for the rollups
+	}
+	s.minute.add(r.Success, r.RTT)
+}
+
+// closeMinute moves the open minute to the ring and opens the one starting
+// at next, closing the hour too when next falls into a later one.
+func (s *series) closeMinute(next time.Time) {
+	s.minutes.push(s.minute)
+	if s.hour.Start.IsZero() {
+		s.hour.Start = s.minute.Start.Truncate(time.Hour)
+	}
+	s.hour.merge(s.minute)
+	if hour := next.Truncate(time.Hour); hour.After(s.hour.Start) {
+		s.hours.push(s.hour)
+		s.hour = Rollup{Start: hour}
+	}
+	s.minute = Rollup{Start: next}
+}
+
+// Targets returns the targets with a history, sorted.
+func (h *History) Targets() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	targets := make([]string, 0, len(h.targets))
+	for t := range h.targets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Query returns the samples of target taken in [from, to), oldest first.
+func (h *History) Query(target string, from, to time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.targets[target]
+	if !ok {
+		return nil
+	}
+	var out []Sample
+	s.samples.each(func(v Sample) {
+		if !v.At.Before(from) && v.At.Before(to) {
+			out = append(out, v)
+		}
+	})
+	return out
+}
+
+// QueryRollups returns the rollups of target with the given resolution,
+// time.Minute or time.Hour, whose period starts in [from, to), oldest
+// first. The current, still open minute or hour is included with the
+// probes counted so far.
+func (h *History) QueryRollups(target string, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.targets[target]
+	if !ok {
+		return nil, nil
+	}
+
+	var closed *ring[Rollup]
+	var open Rollup
+	switch resolution {
+	case time.Minute:
+		closed, open = s.minutes, s.minute
+	case time.Hour:
+		// The open hour lacks the open minute until it closes.
+		closed, open = s.hours, s.hour
+		if !s.minute.Start.IsZero() {
+			if open.Start.IsZero() {
+				open.Start = s.minute.Start.Truncate(time.Hour)
+			}
+			open.merge(s.minute)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported resolution %v", resolution)
+	}
+
+	var out []Rollup
+	in := func(r Rollup) {
+		if r.Sent > 0 && !r.Start.Before(from) && r.Start.Before(to) {
+			out = append(out, r)
+		}
+	}
+	closed.each(in)
+	in(open)
+	return out, nil
+}
+
+// historyFile is the persisted form of a History.
+type historyFile struct {
+	Version int
+	Targets map[string]seriesFile
+}
+
+// seriesFile is the persisted form of a series.
+type seriesFile struct {
+	Samples      []Sample
+	Minutes      []Rollup
+	Hours        []Rollup
+	Minute, Hour Rollup
+}
+
+// historyVersion is the version of the persisted format.
+const historyVersion = 1
+
+// Save writes the history to w.
+func (h *History) Save(w io.Writer) error {
+	h.mu.Lock()
+	file := historyFile{Version: historyVersion, Targets: make(map[string]seriesFile, len(h.targets))}
+	for t, s := range h.targets {
+		file.Targets[t] = seriesFile{
+			Samples: s.samples.slice(),
+			Minutes: s.minutes.slice(),
+			Hours:   s.hours.slice(),
+			Minute:  s.minute,
+			Hour:    s.hour,
+		}
+	}
+	h.mu.Unlock()
+	return gob.NewEncoder(w).Encode(file)
+}
+
+// Load replaces the history with the one read from r. Series longer than
+// the capacities keep their most recent entries.
+func (h *History) Load(r io.Reader) error {
+	var file historyFile
+	if err := gob.NewDecoder(r).Decode(&file); err != nil {
+		return err
+	}
+	if file.Version != historyVersion {
+		return fmt.Errorf("unsupported history version %d", file.Version)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.targets = make(map[string]*series, len(file.Targets))
+	for t, f := range file.Targets {
+		s := h.series(t)
+		for _, v := range f.Samples {
+			s.samples.push(v)
+		}
+		for _, v := range f.Minutes {
+			s.minutes.push(v)
+		}
+		for _, v := range f.Hours {
+			s.hours.push(v)
+		}
+		s.minute, s.hour = f.Minute, f.Hour
+	}
+	return nil
+}
+
+// ring is a fixed-size circular buffer that overwrites its oldest entry.
+// Its buffer grows up to the size as entries arrive, so that short-lived
+// targets do not cost a full buffer.
+type ring[T any] struct {
+	buf   []T
+	size  int
+	start int // index of the oldest entry once the buffer is full
+}
+
+// newRing returns a ring of the given size, or of def when size <= 0.
+func newRing[T any](size, def int) *ring[T] {
+	if size <= 0 {
+		size = def
+	}
+	return &ring[T]{size: size}
+}
+
+func (r *ring[T]) push(v T) {
+	if len(r.buf) < r.size {
+		r.buf = append(r.buf, v)
+		return
+	}
+	r.buf[r.start] = v
+	r.start = (r.start + 1) % r.size
+}
+
+// each calls fn on every entry, oldest first.
+func (r *ring[T]) each(fn func(T)) {
+	for i := range r.buf {
+		fn(r.buf[(r.start+i)%len(r.buf)])
+	}
+}
+
+// slice returns the entries, oldest first.
+func (r *ring[T]) slice() []T {
+	out := make([]T, 0, len(r.buf))
+	r.each(func(v T) { out = append(out, v) })
+	return out
+}
@@ -0,0 +1,146 @@
+package ping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRing_Wraps(t *testing.T) {
+	r := newRing[int](3, 0)
+	for i := 1; i <= 5; i++ {
+		r.push(i)
+	}
+	if got := r.slice(); !reflect.DeepEqual(got, []int{3, 4, 5}) {
+		t.Errorf("expected [3 4 5], got %v", got)
+	}
+}
+
+func TestHistory_Rollups(t *testing.T) {
+	h := NewHistory()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	ok := func(rtt time.Duration) Result { return Result{IP: "h", Success: true, RTT: rtt} }
+	h.Add(t0.Add(10*time.Second), ok(10*time.Millisecond))
+	h.Add(t0.Add(40*time.Second), Result{IP: "h"})
+	h.Add(t0.Add(65*time.Second), ok(20*time.Millisecond))
+	h.Add(t0.Add(time.Hour), ok(30*time.Millisecond))
+	h.Add(t0.Add(30*time.Second), ok(time.Millisecond)) // late: sample only
+
+	minutes, err := h.QueryRollups("h", time.Minute, t0, t0.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rollup{
+		{Start: t0, Sent: 2, Received: 1, Loss: 0.5, MinRTT: 10 * time.Millisecond, AvgRTT: 10 * time.Millisecond, MaxRTT: 10 * time.Millisecond},
+		{Start: t0.Add(time.Minute), Sent: 1, Received: 1, MinRTT: 20 * time.Millisecond, AvgRTT: 20 * time.Millisecond, MaxRTT: 20 * time.Millisecond},
+		{Start: t0.Add(time.Hour), Sent: 1, Received: 1, MinRTT: 30 * time.Millisecond, AvgRTT: 30 * time.Millisecond, MaxRTT: 30 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(minutes, want) {
+		t.Errorf("minutes:\n got %+v\nwant %+v", minutes, want)
+	}
+
+	hours, err := h.QueryRollups("h", time.Hour, t0, t0.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 2 {
+		t.Fatalf("expected 2 hours, got %+v", hours)
+	}
+	first := hours[0]
+	if first.Start != t0 || first.Sent != 3 || first.Received != 2 || first.MinRTT != 10*time.Millisecond ||
+		first.AvgRTT != 15*time.Millisecond || first.MaxRTT != 20*time.Millisecond || first.Loss < 0.33 || first.Loss > 0.34 {
+		t.Errorf("unexpected first hour %+v", first)
+	}
+	if hours[1].Start != t0.Add(time.Hour) || hours[1].Sent != 1 {
+		t.Errorf("expected the open hour to hold the open minute, got %+v", hours[1])
+	}
+
+	if got, _ := h.QueryRollups("h", time.Minute, t0.Add(time.Minute), t0.Add(time.Hour)); len(got) != 1 {
+		t.Errorf("expected one minute in range, got %+v", got)
+	}
+	if _, err := h.QueryRollups("h", time.Second, t0, t0.Add(time.Hour)); err == nil {
+		t.Error("expected error for an unsupported resolution")
+	}
+}
+
+func TestHistory_Query(t *testing.T) {
+	h := NewHistory()
+	h.Samples = 3
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		h.Add(t0.Add(time.Duration(i)*time.Second), Result{IP: "h", Success: i%2 == 0})
+	}
+	h.Add(t0, Result{IP: "other"})
+
+	got := h.Query("h", t0, t0.Add(4*time.Second))
+	if len(got) != 2 || !got[0].At.Equal(t0.Add(2*time.Second)) || !got[1].At.Equal(t0.Add(3*time.Second)) {
+		t.Errorf("expected samples 2 and 3 of the last three, got %+v", got)
+	}
+	if got := h.Query("missing", t0, t0.Add(time.Hour)); got != nil {
+		t.Errorf("expected no samples, got %+v", got)
+	}
+	if targets := h.Targets(); !reflect.DeepEqual(targets, []string{"h", "other"}) {
+		t.Errorf("unexpected targets %v", targets)
+	}
+}
+
+func TestHistory_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.gob")
+	h, err := OpenHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		h.Add(t0.Add(time.Duration(i)*time.Minute), Result{IP: "h", Success: true, RTT: time.Duration(i+1) * time.Millisecond})
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := OpenHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Query("h", t0, t0.Add(time.Hour)), h.Query("h", t0, t0.Add(time.Hour)); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples differ after reload:\n got %+v\nwant %+v", got, want)
+	}
+	got, _ := loaded.QueryRollups("h", time.Minute, t0, t0.Add(time.Hour))
+	want, _ := h.QueryRollups("h", time.Minute, t0, t0.Add(time.Hour))
+	if len(got) != 5 || !reflect.DeepEqual(got, want) {
+		t.Errorf("rollups differ after reload:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Rollups keep accumulating after a reload.
+	loaded.Add(t0.Add(5*time.Minute), Result{IP: "h", Success: true, RTT: time.Millisecond})
+	if got, _ := loaded.QueryRollups("h", time.Minute, t0, t0.Add(time.Hour)); len(got) != 6 {
+		t.Errorf("expected 6 minutes after reload, got %d", len(got))
+	}
+
+	// A smaller capacity keeps the newest entries.
+	small := NewHistory()
+	small.Samples = 2
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := small.Load(f); err != nil {
+		t.Fatal(err)
+	}
+	if s := small.Query("h", t0, t0.Add(time.Hour)); len(s) != 2 || !s[1].At.Equal(t0.Add(4*time.Minute)) {
+		t.Errorf("expected the two newest samples, got %+v", s)
+	}
+}
+
+func TestOpenHistory_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.gob")
+	if err := os.WriteFile(path, []byte("not a history"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHistory(path); err == nil {
+		t.Error("expected error for a corrupt history file")
+	}
+}
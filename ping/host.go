@@ -0,0 +1,131 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Verdict selects how PingHost combines the results of the addresses of a
+// host.
+type Verdict int
+
+const (
+	// VerdictAny judges a host reachable when any of its addresses answers.
+	VerdictAny Verdict = iota
+	// VerdictAll judges a host reachable only when every address answers.
+	VerdictAll
+)
+
+func (v Verdict) String() string {
+	if v == VerdictAll {
+		return "all"
+	}
+	return "any"
+}
+
+// HostOptions configures PingHost.
+type HostOptions struct {
+	Options
+	Verdict Verdict
+	// Prefer orders the addresses by family, for instance FamilyIPv6
+	// first; addresses of one family keep the resolver's order. Nil puts
+	// IPv4 first, as PingContext does. Options.Family, when set, still
+	// restricts the addresses probed to that family.
+	Prefer []Family
+}
+
+// HostResult is the outcome of PingHost.
+type HostResult struct {
+	Host      string
+	Results   []Result // one per address, in preference order; IP is the address
+	Reachable bool     // the verdict over Results
+	Err       error    // resolution error; Results is then empty
+}
+
+// PingHost resolves every address of host and pings them all. It is
+// PingHostContext without a context.
+func PingHost(host string, opts *HostOptions) HostResult {
+	return PingHostContext(context.Background(), host, opts)
+}
+
+// PingHostContext resolves every A and AAAA record of host, pings each
+// address once as PingContext does, concurrently, and judges the host by
+// opts.Verdict; a nil opts uses the defaults. Unlike PingContext, which
+// probes the first address only, a host is not judged by whichever record
+// the resolver returned first.
+func PingHostContext(ctx context.Context, host string, opts *HostOptions) HostResult {
+	if opts == nil {
+		opts = &HostOptions{}
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return HostResult{Host: host, Err: fmt.Errorf("resolve: %w", err)}
+	}
+	ips := opts.order(addrs)
+	if len(ips) == 0 {
+		return HostResult{Host: host, Err: fmt.Errorf("resolve: no %s address for %s", opts.Family, host)}
+	}
+	return opts.pingAll(ctx, host, ips)
+}
+
+// order returns the distinct addresses of the configured family, sorted by
+// family preference. IPv6 zones are kept.
+func (o *HostOptions) order(addrs []net.IPAddr) []net.IPAddr {
+	prefer := o.Prefer
+	if prefer == nil {
+		prefer = []Family{FamilyIPv4, FamilyIPv6}
+	}
+	rank := func(ip net.IP) int {
+		for i, f := range prefer {
+			if f == familyOf(ip) {
+				return i
+			}
+		}
+		return len(prefer)
+	}
+
+	var ips []net.IPAddr
+	seen := make(map[string]bool)
+	for _, a := range addrs {
+		if o.Family != FamilyAuto && familyOf(a.IP) != o.Family {
+			continue
+		}
+		if key := string(a.IP.To16()) + a.Zone; !seen[key] {
+			seen[key] = true
+			ips = append(ips, a)
+		}
+	}
+	sort.SliceStable(ips, func(i, j int) bool { return rank(ips[i].IP) < rank(ips[j].IP) })
+	return ips
+}
+
+// pingAll pings the addresses ips of host concurrently. Addresses are
+// passed on with their zone, so that link-local ones go out the right
+// interface.
+func (o *HostOptions) pingAll(ctx context.Context, host string, ips []net.IPAddr) HostResult {
+	res := HostResult{Host: host, Results: make([]Result, len(ips))}
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip net.IPAddr) {
+			defer wg.Done()
+			opts := o.Options
+			opts.Family = familyOf(ip.IP)
+			res.Results[i] = PingContext(ctx, ip.String(), &opts)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	res.Reachable = o.Verdict == VerdictAll
+	for _, r := range res.Results {
+		if o.Verdict == VerdictAll {
+			res.Reachable = res.Reachable && r.Success
+		} else {
+			res.Reachable = res.Reachable || r.Success
+		}
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostOptions_Order(t *testing.T) {
+	addrs := []net.IPAddr{
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("192.0.2.1")},
+		{IP: net.ParseIP("2001:db8::2")},
+		{IP: net.ParseIP("192.0.2.2")},
+		{IP: net.ParseIP("192.0.2.1")},
+	}
+	for _, c := range []struct {
+		opts HostOptions
+		want []string
+	}{
+		{HostOptions{}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"}},
+		{HostOptions{Prefer: []Family{FamilyIPv6}}, []string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"}},
+		{HostOptions{Options: Options{Family: FamilyIPv4}, Prefer: []Family{FamilyIPv6}}, []string{"192.0.2.1", "192.0.2.2"}},
+	} {
+		var got []string
+		for _, ip := range c.opts.order(addrs) {
+			got = append(got, ip.String())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("prefer %v: got %v, want %v", c.opts.Prefer, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("prefer %v: got %v, want %v", c.opts.Prefer, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPingHost_FakeVerdict(t *testing.T) {
+	alive := net.ParseIP("192.0.2.1")
+	listen := listenEcho(alive)
+	ips := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("192.0.2.2")}, {IP: net.ParseIP("2001:db8::1")}}
+
+	for _, v := range []Verdict{VerdictAny, VerdictAll} {
+		opts := &HostOptions{Options: Options{Timeout: 50 * time.Millisecond, Listen: listen}, Verdict: v}
+		res := opts.pingAll(context.Background(), "example.net", ips)
+		if len(res.Results) != 3 {
+			t.Fatalf("%v: expected 3 results, got %d", v, len(res.Results))
+		}
+		if !res.Results[0].Success || res.Results[1].Success || res.Results[2].Success {
+			t.Errorf("%v: unexpected results %+v", v, res.Results)
+		}
+		if res.Results[2].IP != "2001:db8::1" {
+			t.Errorf("%v: results out of order: %+v", v, res.Results)
+		}
+		if want := v == VerdictAny; res.Reachable != want {
+			t.Errorf("%v: expected reachable %v", v, want)
+		}
+	}
+}
+
+// zoneConn records the zone of the addresses written to.
+type zoneConn struct {
+	Conn
+	zones chan<- string
+}
+
+func (c zoneConn) WriteTo(b []byte, dst *net.IPAddr) (int, error) {
+	c.zones <- dst.Zone
+	return c.Conn.WriteTo(b, dst)
+}
+
+func TestPingHost_FakeZone(t *testing.T) {
+	zones := make(chan string, 1)
+	listen := func(fam Family) (Conn, error) {
+		c, err := listenEcho()(fam)
+		return zoneConn{c, zones}, err
+	}
+	opts := &HostOptions{Options: Options{Timeout: 50 * time.Millisecond, Listen: listen}}
+	res := opts.pingAll(context.Background(), "router.local", []net.IPAddr{{IP: net.ParseIP("fe80::1"), Zone: "eth7"}})
+	if !res.Reachable {
+		t.Fatalf("unexpected result %+v", res.Results)
+	}
+	if zone := <-zones; zone != "eth7" {
+		t.Errorf("expected the probe to keep zone eth7, got %q", zone)
+	}
+}
+
+func TestPingHost_ResolveError(t *testing.T) {
+	res := PingHost("192.0.2.1", &HostOptions{Options: Options{Family: FamilyIPv6}})
+	if res.Err == nil || res.Reachable || len(res.Results) != 0 {
+		t.Errorf("expected a resolution error, got %+v", res)
+	}
+}
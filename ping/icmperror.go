@@ -0,0 +1,160 @@
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// codeFragmentationNeeded is the ICMPv4 destination unreachable code sent
+// when a packet with the Don't Fragment flag exceeds the next-hop MTU.
+const codeFragmentationNeeded = 4
+
+// unreachableReasons describe destination unreachable codes (RFC 792,
+// RFC 1812 for IPv4; RFC 4443 for IPv6).
+var unreachableReasons = map[Family][]string{
+	FamilyIPv4: {
+		"net unreachable",
+		"host unreachable",
+		"protocol unreachable",
+		"port unreachable",
+		"fragmentation needed",
+		"source route failed",
+		"destination network unknown",
+		"destination host unknown",
+		"source host isolated",
+		"network administratively prohibited",
+		"host administratively prohibited",
+		"network unreachable for TOS",
+		"host unreachable for TOS",
+		"communication administratively prohibited",
+		"host precedence violation",
+		"precedence cutoff in effect",
+	},
+	FamilyIPv6: {
+		"no route to destination",
+		"communication administratively prohibited",
+		"beyond scope of source address",
+		"address unreachable",
+		"port unreachable",
+		"source address failed ingress/egress policy",
+		"reject route to destination",
+	},
+}
+
+// UnreachableError is reported in Result.Err when a router or the target
+// answers a probe with an ICMP destination unreachable message.
+type UnreachableError struct {
+	Family Family
+	Code   uint8
+	From   net.IP // sender of the ICMP message
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("destination unreachable from %s: %s", e.From, e.Reason())
+}
+
+// Reason describes the unreachable code in words.
+func (e *UnreachableError) Reason() string {
+	if reasons := unreachableReasons[e.Family]; int(e.Code) < len(reasons) {
+		return reasons[e.Code]
+	}
+	return fmt.Sprintf("code %d", e.Code)
+}
+
+// Prohibited reports whether the probe was dropped by a filter rather than
+// because the destination is down or has no route.
+func (e *UnreachableError) Prohibited() bool {
+	if e.Family == FamilyIPv6 {
+		return e.Code == 1 || e.Code == 5 || e.Code == 6
+	}
+	return e.Code == 9 || e.Code == 10 || e.Code == 13
+}
+
+// TimeExceededError is reported in Result.Err when a router discards a probe
+// because its TTL (hop limit) ran out, typically due to a routing loop.
+type TimeExceededError struct {
+	Family Family
+	Code   uint8
+	From   net.IP // sender of the ICMP message
+}
+
+func (e *TimeExceededError) Error() string {
+	reason := "time to live exceeded in transit"
+	if e.Code == 1 {
+		reason = "fragment reassembly time exceeded"
+	}
+	return fmt.Sprintf("time exceeded from %s: %s", e.From, reason)
+}
+
+// PacketTooBigError is reported in Result.Err when a probe exceeded the MTU
+// of a link on the path: an ICMPv4 fragmentation needed message for packets
+// with the Don't Fragment flag, or an ICMPv6 packet too big message.
+type PacketTooBigError struct {
+	Family Family
+	MTU    int    // next-hop MTU reported by the router; 0 if unknown
+	From   net.IP // sender of the ICMP message
+}
+
+func (e *PacketTooBigError) Error() string {
+	if e.MTU == 0 {
+		return fmt.Sprintf("packet too big from %s", e.From)
+	}
+	return fmt.Sprintf("packet too big from %s: next-hop MTU %d", e.From, e.MTU)
+}
+
+// parseEchoResponse interprets msg, received from the address from, as the
+// response to one of our echo requests carrying id: either an echo reply or
+// an ICMP error quoting the request. The returned reply names the probed
+// address in from, and carries a typed error for ICMP errors. The caller
+// fills in ttl and at.
+func parseEchoResponse(fam Family, id uint16, msg []byte, from net.IP) (echoReply, bool) {
+	typ, code, rid, seq, body, err := ParsePacket(msg)
+	if err != nil {
+		return echoReply{}, false
+	}
+
+	switch typ {
+	case fam.echoReply():
+		if rid != id {
+			return echoReply{}, false
+		}
+		r := echoReply{seq: seq, from: from, size: len(msg), payload: append([]byte(nil), body...)}
+		// Raw IPv4 sockets see replies before the kernel checks them. The
+		// ICMPv6 checksum covers addresses we may not know, and the
+		// kernel verifies it anyway.
+		r.badChecksum = fam == FamilyIPv4 && !ValidateChecksum(msg)
+		return r, true
+
+	case fam.destinationUnreachable(), fam.timeExceeded(), packetTooBigType(fam):
+		dst, qid, qseq, ok := parseQuotedEcho(fam, body)
+		if !ok || qid != id {
+			return echoReply{}, false
+		}
+		r := echoReply{seq: qseq, from: dst, size: len(msg)}
+		switch {
+		case typ == fam.timeExceeded():
+			r.err = &TimeExceededError{Family: fam, Code: code, From: from}
+		case fam == FamilyIPv6 && typ == TypePacketTooBigV6:
+			r.err = &PacketTooBigError{Family: fam, MTU: int(binary.BigEndian.Uint32(msg[4:8])), From: from}
+		case fam == FamilyIPv4 && code == codeFragmentationNeeded:
+			// RFC 1191 carries the next-hop MTU in the low half of the
+			// otherwise unused header word.
+			r.err = &PacketTooBigError{Family: fam, MTU: int(seq), From: from}
+		default:
+			r.err = &UnreachableError{Family: fam, Code: code, From: from}
+		}
+		return r, true
+	}
+	return echoReply{}, false
+}
+
+// packetTooBigType returns the ICMP type of packet too big messages for the
+// family. IPv4 reports them as destination unreachable, so its value is that
+// type as well.
+func packetTooBigType(fam Family) uint8 {
+	if fam == FamilyIPv6 {
+		return TypePacketTooBigV6
+	}
+	return TypeDestinationUnreachable
+}
@@ -0,0 +1,85 @@
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// ICMPv6 type constants (RFC 4443)
+const (
+	TypeDestinationUnreachableV6 = 1
+	TypePacketTooBigV6           = 2
+	TypeTimeExceededV6           = 3
+	TypeParameterProblemV6       = 4
+	TypeEchoRequestV6            = 128
+	TypeEchoReplyV6              = 129
+)
+
+// protoICMPv6 is the IPv6 next-header value for ICMPv6.
+const protoICMPv6 = 58
+
+// buildEchoRequestV6 creates a serialised ICMPv6 echo request. When src is
+// known the checksum is computed over the RFC 4443 pseudo-header; otherwise
+// it is left zero for the kernel to fill in, which Linux always does for
+// ICMPv6 sockets.
+func buildEchoRequestV6(id, seq uint16, ts time.Time, src, dst net.IP) []byte {
+	return marshalEchoRequestV6(id, seq, timestampPayload(ts, 8), src, dst)
+}
+
+// marshalEchoRequestV6 serialises an ICMPv6 echo request carrying payload.
+func marshalEchoRequestV6(id, seq uint16, payload []byte, src, dst net.IP) []byte {
+	m := Message{Family: FamilyIPv6, Type: TypeEchoRequestV6, Body: &Echo{ID: id, Seq: seq, Data: payload}}
+	pkt, _ := m.Marshal(src, dst) // echo bodies always marshal
+	return pkt
+}
+
+// icmpv6Checksum computes the ICMPv6 checksum of msg, whose checksum field
+// must be zero, including the IPv6 pseudo-header (RFC 8200 section 8.1).
+func icmpv6Checksum(src, dst net.IP, msg []byte) uint16 {
+	ph := make([]byte, 40, 40+len(msg))
+	copy(ph[0:16], src.To16())
+	copy(ph[16:32], dst.To16())
+	binary.BigEndian.PutUint32(ph[32:36], uint32(len(msg)))
+	ph[39] = protoICMPv6
+	return ipChecksum(append(ph, msg...))
+}
+
+// ValidateChecksumV6 returns true when the ICMPv6 message exchanged between
+// src and dst carries a correct checksum. data must begin at the ICMPv6
+// header.
+func ValidateChecksumV6(src, dst net.IP, data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	saved := binary.BigEndian.Uint16(data[2:4])
+	data[2], data[3] = 0, 0
+	got := icmpv6Checksum(src, dst, data)
+	data[2], data[3] = byte(saved>>8), byte(saved&0xFF)
+	return got == saved
+}
+
+// sourceFor returns the local address the kernel would use to reach dst, or
+// nil if no route exists. Connecting a UDP socket performs the route lookup
+// without sending any packet.
+func sourceFor(dst net.IP) net.IP {
+	network := "udp6"
+	if dst.To4() != nil {
+		network = "udp4"
+	}
+	c, err := net.DialUDP(network, nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP
+}
+
+// buildEcho creates an echo request carrying payload for the given family.
+// src is only used for the ICMPv6 checksum and may be nil.
+func buildEcho(f Family, id, seq uint16, payload []byte, src, dst net.IP) []byte {
+	if f == FamilyIPv6 {
+		return marshalEchoRequestV6(id, seq, payload, src, dst)
+	}
+	return marshalEchoRequest(id, seq, payload)
+}
@@ -0,0 +1,252 @@
+package ping
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultRTTBuckets are the histogram bucket bounds used by
+// NewLatencyRecorder, from LAN to intercontinental round trips.
+var DefaultRTTBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Latency describes the distribution of the RTTs of a series of replies.
+type Latency struct {
+	P50, P90, P99 time.Duration // streaming percentile estimates
+	// Jitter is the RFC 3550 interarrival jitter: a running average of the
+	// RTT difference between consecutive replies, smoothed by 1/16.
+	Jitter    time.Duration
+	Histogram *Histogram
+}
+
+// LatencyRecorder accumulates RTTs into a Latency in constant memory.
+// Percentiles are estimated with the P² algorithm (Jain and Chlamtac, 1985),
+// so they are exact for up to five samples and approximate beyond.
+type LatencyRecorder struct {
+	p50, p90, p99 p2Estimator
+	jitter        float64
+	last          time.Duration
+	count         int
+	hist          *Histogram
+}
+
+// NewLatencyRecorder returns an empty recorder whose histogram uses
+// DefaultRTTBuckets.
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{
+		p50:  p2Estimator{p: 0.50},
+		p90:  p2Estimator{p: 0.90},
+		p99:  p2Estimator{p: 0.99},
+		hist: NewHistogram(DefaultRTTBuckets...),
+	}
+}
+
+// Add records the RTT of the next reply, in the order the probes were sent.
+func (l *LatencyRecorder) Add(rtt time.Duration) {
+	x := float64(rtt)
+	l.p50.add(x)
+	l.p90.add(x)
+	l.p99.add(x)
+	l.hist.Add(rtt)
+
+	if l.count > 0 {
+		d := math.Abs(float64(rtt - l.last))
+		l.jitter += (d - l.jitter) / 16
+	}
+	l.last = rtt
+	l.count++
+}
+
+// Latency returns the figures recorded so far. The histogram is a copy.
+func (l *LatencyRecorder) Latency() Latency {
+	return Latency{
+		P50:       time.Duration(l.p50.value()),
+		P90:       time.Duration(l.p90.value()),
+		P99:       time.Duration(l.p99.value()),
+		Jitter:    time.Duration(l.jitter),
+		Histogram: l.hist.clone(),
+	}
+}
+
+// p2Estimator estimates the p-quantile of a stream with five markers whose
+// heights are adjusted by piecewise-parabolic interpolation.
+type p2Estimator struct {
+	p    float64
+	n    int
+	q    [5]float64 // marker heights
+	pos  [5]float64 // marker positions, 1-based
+	want [5]float64 // desired marker positions
+}
+
+func (e *p2Estimator) add(x float64) {
+	if e.n < 5 {
+		e.q[e.n] = x
+		e.n++
+		if e.n == 5 {
+			sort.Float64s(e.q[:])
+			e.pos = [5]float64{1, 2, 3, 4, 5}
+			e.want = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.n++
+
+	// Find the cell holding x, extending the extremes when needed.
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for x >= e.q[k+1] {
+			k++
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.pos[i]++
+	}
+	inc := [5]float64{0, e.p / 2, e.p, (1 + e.p) / 2, 1}
+	for i := range e.want {
+		e.want[i] += inc[i]
+	}
+
+	// Move the middle markers towards their desired positions.
+	for i := 1; i <= 3; i++ {
+		d := e.want[i] - e.pos[i]
+		if (d >= 1 && e.pos[i+1]-e.pos[i] > 1) || (d <= -1 && e.pos[i-1]-e.pos[i] < -1) {
+			s := math.Copysign(1, d)
+			q := e.parabolic(i, s)
+			if q <= e.q[i-1] || q >= e.q[i+1] {
+				q = e.linear(i, s)
+			}
+			e.q[i] = q
+			e.pos[i] += s
+		}
+	}
+}
+
+func (e *p2Estimator) parabolic(i int, d float64) float64 {
+	return e.q[i] + d/(e.pos[i+1]-e.pos[i-1])*
+		((e.pos[i]-e.pos[i-1]+d)*(e.q[i+1]-e.q[i])/(e.pos[i+1]-e.pos[i])+
+			(e.pos[i+1]-e.pos[i]-d)*(e.q[i]-e.q[i-1])/(e.pos[i]-e.pos[i-1]))
+}
+
+func (e *p2Estimator) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.q[i] + d*(e.q[j]-e.q[i])/(e.pos[j]-e.pos[i])
+}
+
+// value returns the current estimate; with fewer than five samples it is the
+// nearest-rank quantile of the samples seen.
+func (e *p2Estimator) value() float64 {
+	if e.n == 0 {
+		return 0
+	}
+	if e.n < 5 {
+		s := append([]float64(nil), e.q[:e.n]...)
+		sort.Float64s(s)
+		return s[int(math.Ceil(e.p*float64(e.n)))-1]
+	}
+	return e.q[2]
+}
+
+// Histogram counts RTTs in fixed buckets. Histograms with the same bounds
+// can be merged, for instance to aggregate the RTTs of many hosts.
+type Histogram struct {
+	Bounds []time.Duration // inclusive upper bounds of the buckets, ascending
+	Counts []uint64        // one per bound, plus one for RTTs above the last
+	Count  uint64
+	Sum    time.Duration
+}
+
+// NewHistogram returns an empty histogram with the given ascending bucket
+// bounds.
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	return &Histogram{
+		Bounds: append([]time.Duration(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Add counts one RTT.
+func (h *Histogram) Add(rtt time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return rtt <= h.Bounds[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += rtt
+}
+
+// Merge adds the counts of o to h. Both must use the same bounds.
+func (h *Histogram) Merge(o *Histogram) error {
+	if len(h.Bounds) != len(o.Bounds) || len(h.Counts) != len(o.Counts) {
+		return errors.New("histogram bounds differ")
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != o.Bounds[i] {
+			return errors.New("histogram bounds differ")
+		}
+	}
+	for i := range h.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+	return nil
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) by interpolating linearly
+// within the bucket that holds it. RTTs above the last bound are reported as
+// that bound.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var seen float64
+	for i, c := range h.Counts {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+		if i == len(h.Bounds) {
+			break
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		}
+		frac := (rank - seen) / float64(c)
+		return lower + time.Duration(frac*float64(h.Bounds[i]-lower))
+	}
+	if len(h.Bounds) == 0 {
+		return 0
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// clone returns a deep copy of h.
+func (h *Histogram) clone() *Histogram {
+	c := *h
+	c.Bounds = append([]time.Duration(nil), h.Bounds...)
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return &c
+}
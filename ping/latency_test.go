@@ -0,0 +1,114 @@
+package ping
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestP2Estimator_FewSamplesAreExact(t *testing.T) {
+	e := p2Estimator{p: 0.5}
+	for _, x := range []float64{30, 10, 20} {
+		e.add(x)
+	}
+	if got := e.value(); got != 20 {
+		t.Errorf("expected median 20, got %v", got)
+	}
+	e = p2Estimator{p: 0.99}
+	e.add(1)
+	e.add(7)
+	if got := e.value(); got != 7 {
+		t.Errorf("expected p99 7, got %v", got)
+	}
+}
+
+func TestP2Estimator_Uniform(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		e := p2Estimator{p: p}
+		for _, i := range rng.Perm(10000) {
+			e.add(float64(i + 1))
+		}
+		want := p * 10000
+		if got := e.value(); got < want*0.98 || got > want*1.02 {
+			t.Errorf("p%v: expected about %v, got %v", p*100, want, got)
+		}
+	}
+}
+
+func TestLatencyRecorder_Jitter(t *testing.T) {
+	l := NewLatencyRecorder()
+	for i := 0; i < 10; i++ {
+		l.Add(10 * time.Millisecond)
+	}
+	if j := l.Latency().Jitter; j != 0 {
+		t.Errorf("constant RTT: expected zero jitter, got %v", j)
+	}
+
+	l = NewLatencyRecorder()
+	l.Add(10 * time.Millisecond)
+	l.Add(26 * time.Millisecond)
+	// J = 0 + (16ms - 0) / 16
+	if j := l.Latency().Jitter; j != time.Millisecond {
+		t.Errorf("expected 1ms jitter, got %v", j)
+	}
+}
+
+func TestHistogram_AddAndQuantile(t *testing.T) {
+	h := NewHistogram(10*time.Millisecond, 20*time.Millisecond)
+	for _, rtt := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 15 * time.Millisecond, 30 * time.Millisecond} {
+		h.Add(rtt)
+	}
+	if h.Counts[0] != 2 || h.Counts[1] != 1 || h.Counts[2] != 1 || h.Count != 4 {
+		t.Fatalf("unexpected counts %v", h.Counts)
+	}
+	if h.Sum != 60*time.Millisecond {
+		t.Errorf("expected sum 60ms, got %v", h.Sum)
+	}
+	if got := h.Quantile(0.5); got != 10*time.Millisecond {
+		t.Errorf("expected median 10ms, got %v", got)
+	}
+	if got := h.Quantile(0.625); got != 15*time.Millisecond {
+		t.Errorf("expected p62.5 15ms, got %v", got)
+	}
+	if got := h.Quantile(1); got != 20*time.Millisecond {
+		t.Errorf("overflow should report the last bound, got %v", got)
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	a := NewHistogram(DefaultRTTBuckets...)
+	b := NewHistogram(DefaultRTTBuckets...)
+	a.Add(time.Millisecond)
+	b.Add(time.Second)
+	b.Add(time.Second)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != 3 || a.Sum != 2*time.Second+time.Millisecond {
+		t.Errorf("unexpected merged histogram %+v", a)
+	}
+	if err := a.Merge(NewHistogram(time.Second)); err == nil {
+		t.Error("expected error merging different bounds")
+	}
+}
+
+func TestStatisticsSummarize_Latency(t *testing.T) {
+	s := &Statistics{Sent: 4, Results: []Result{
+		{Success: true, RTT: 10 * time.Millisecond},
+		{Success: true, RTT: 20 * time.Millisecond},
+		{},
+		{Success: true, RTT: 30 * time.Millisecond},
+	}}
+	s.summarize()
+	l := s.Latency
+	if l.P50 != 20*time.Millisecond || l.P99 != 30*time.Millisecond {
+		t.Errorf("unexpected percentiles %+v", l)
+	}
+	if l.Histogram == nil || l.Histogram.Count != 3 {
+		t.Errorf("expected 3 RTTs in the histogram, got %+v", l.Histogram)
+	}
+	if l.Jitter == 0 {
+		t.Error("expected non-zero jitter")
+	}
+}
@@ -0,0 +1,320 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Message is an ICMP or ICMPv6 message. Family selects the protocol, and so
+// how Type is interpreted: ICMPv6 for FamilyIPv6, ICMP otherwise.
+type Message struct {
+	Family   Family
+	Type     uint8
+	Code     uint8
+	Checksum uint16 // as received; Marshal computes it
+	Body     Body
+}
+
+// Body is the part of a Message that follows its type, code and checksum.
+type Body interface {
+	// Len returns the length of the marshalled body.
+	Len(fam Family) int
+	// Marshal returns the wire format of the body.
+	Marshal(fam Family) ([]byte, error)
+}
+
+// ParseMessage parses an ICMP message of the given family. data must begin
+// at the ICMP header.
+func ParseMessage(fam Family, data []byte) (*Message, error) {
+	m := &Message{Family: fam}
+	if err := m.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Marshal returns the wire format of m with its checksum computed. The
+// ICMPv6 checksum covers the IPv6 addresses src and dst; when either is nil
+// it is left zero, for the kernel to fill in as Linux does for ICMPv6
+// sockets. src and dst are not used for ICMP.
+func (m *Message) Marshal(src, dst net.IP) ([]byte, error) {
+	b := make([]byte, 4)
+	b[0], b[1] = m.Type, m.Code
+	if m.Body == nil {
+		// The rest of the header, unused.
+		b = append(b, 0, 0, 0, 0)
+	} else {
+		body, err := m.Body.Marshal(m.Family)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, body...)
+	}
+
+	switch {
+	case m.Family != FamilyIPv6:
+		binary.BigEndian.PutUint16(b[2:4], ipChecksum(b))
+	case src != nil && dst != nil:
+		binary.BigEndian.PutUint16(b[2:4], icmpv6Checksum(src, dst, b))
+	}
+	return b, nil
+}
+
+// Unmarshal parses data, which must begin at the ICMP header, into m. The
+// protocol is taken from m.Family. Messages of types without a dedicated
+// Body are returned with a *RawBody. The checksum is not verified; see
+// ValidateChecksum and ValidateChecksumV6.
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("message too short: %d bytes", len(data))
+	}
+	m.Type, m.Code = data[0], data[1]
+	m.Checksum = binary.BigEndian.Uint16(data[2:4])
+	b := data[4:]
+
+	var err error
+	v6 := m.Family == FamilyIPv6
+	switch {
+	case !v6 && (m.Type == TypeEchoRequest || m.Type == TypeEchoReply),
+		v6 && (m.Type == TypeEchoRequestV6 || m.Type == TypeEchoReplyV6):
+		m.Body, err = parseEcho(b)
+	case !v6 && m.Type == TypeDestinationUnreachable,
+		v6 && m.Type == TypeDestinationUnreachableV6:
+		m.Body, err = parseDstUnreach(m.Family, b)
+	case !v6 && m.Type == TypeTimeExceeded,
+		v6 && m.Type == TypeTimeExceededV6:
+		m.Body, err = parseTimeExceeded(m.Family, b)
+	case !v6 && m.Type == TypeParameterProblem,
+		v6 && m.Type == TypeParameterProblemV6:
+		m.Body, err = parseParamProb(m.Family, b)
+	case v6 && m.Type == TypePacketTooBigV6:
+		m.Body, err = parsePacketTooBig(b)
+	case !v6 && (m.Type == TypeTimestamp || m.Type == TypeTimestampReply):
+		m.Body, err = parseTimestampBody(b)
+	default:
+		m.Body = &RawBody{Data: append([]byte(nil), b...)}
+	}
+	return err
+}
+
+// errShortBody is returned for bodies shorter than their fixed part.
+var errShortBody = errors.New("message body too short")
+
+// RawBody is the body of a message of a type without a dedicated Body.
+type RawBody struct {
+	Data []byte
+}
+
+// Len implements Body.
+func (b *RawBody) Len(Family) int { return len(b.Data) }
+
+// Marshal implements Body.
+func (b *RawBody) Marshal(Family) ([]byte, error) {
+	return append([]byte(nil), b.Data...), nil
+}
+
+// Echo is the body of an echo request or reply.
+type Echo struct {
+	ID   uint16
+	Seq  uint16
+	Data []byte
+}
+
+// Len implements Body.
+func (e *Echo) Len(Family) int { return 4 + len(e.Data) }
+
+// Marshal implements Body.
+func (e *Echo) Marshal(Family) ([]byte, error) {
+	b := make([]byte, 4+len(e.Data))
+	binary.BigEndian.PutUint16(b[0:2], e.ID)
+	binary.BigEndian.PutUint16(b[2:4], e.Seq)
+	copy(b[4:], e.Data)
+	return b, nil
+}
+
+func parseEcho(b []byte) (*Echo, error) {
+	if len(b) < 4 {
+		return nil, errShortBody
+	}
+	return &Echo{
+		ID:   binary.BigEndian.Uint16(b[0:2]),
+		Seq:  binary.BigEndian.Uint16(b[2:4]),
+		Data: append([]byte(nil), b[4:]...),
+	}, nil
+}
+
+// TimestampBody is the body of an ICMP timestamp request or reply (RFC
+// 792). The timestamps are in milliseconds since midnight UT.
+type TimestampBody struct {
+	ID        uint16
+	Seq       uint16
+	Originate uint32
+	Receive   uint32
+	Transmit  uint32
+}
+
+// Len implements Body.
+func (t *TimestampBody) Len(Family) int { return 16 }
+
+// Marshal implements Body.
+func (t *TimestampBody) Marshal(Family) ([]byte, error) {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b[0:2], t.ID)
+	binary.BigEndian.PutUint16(b[2:4], t.Seq)
+	binary.BigEndian.PutUint32(b[4:8], t.Originate)
+	binary.BigEndian.PutUint32(b[8:12], t.Receive)
+	binary.BigEndian.PutUint32(b[12:16], t.Transmit)
+	return b, nil
+}
+
+func parseTimestampBody(b []byte) (*TimestampBody, error) {
+	if len(b) < 16 {
+		return nil, errShortBody
+	}
+	return &TimestampBody{
+		ID:        binary.BigEndian.Uint16(b[0:2]),
+		Seq:       binary.BigEndian.Uint16(b[2:4]),
+		Originate: binary.BigEndian.Uint32(b[4:8]),
+		Receive:   binary.BigEndian.Uint32(b[8:12]),
+		Transmit:  binary.BigEndian.Uint32(b[12:16]),
+	}, nil
+}
+
+// DstUnreach is the body of a destination unreachable message.
+type DstUnreach struct {
+	// MTU is the next-hop MTU of an ICMP fragmentation needed message
+	// (RFC 1191); it does not exist in ICMPv6.
+	MTU        uint16
+	Data       []byte      // leading part of the datagram that failed
+	Extensions []Extension // RFC 4884 extension objects
+}
+
+// Len implements Body.
+func (u *DstUnreach) Len(fam Family) int { return errorBodyLen(fam, u.Data, u.Extensions) }
+
+// Marshal implements Body.
+func (u *DstUnreach) Marshal(fam Family) ([]byte, error) {
+	b, err := marshalErrorBody(fam, u.Data, u.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	if fam != FamilyIPv6 {
+		binary.BigEndian.PutUint16(b[2:4], u.MTU)
+	}
+	return b, nil
+}
+
+func parseDstUnreach(fam Family, b []byte) (*DstUnreach, error) {
+	data, exts, err := parseErrorBody(fam, b)
+	if err != nil {
+		return nil, err
+	}
+	u := &DstUnreach{Data: data, Extensions: exts}
+	if fam != FamilyIPv6 {
+		u.MTU = binary.BigEndian.Uint16(b[2:4])
+	}
+	return u, nil
+}
+
+// TimeExceeded is the body of a time exceeded message.
+type TimeExceeded struct {
+	Data       []byte      // leading part of the datagram that expired
+	Extensions []Extension // RFC 4884 extension objects
+}
+
+// Len implements Body.
+func (t *TimeExceeded) Len(fam Family) int { return errorBodyLen(fam, t.Data, t.Extensions) }
+
+// Marshal implements Body.
+func (t *TimeExceeded) Marshal(fam Family) ([]byte, error) {
+	return marshalErrorBody(fam, t.Data, t.Extensions)
+}
+
+func parseTimeExceeded(fam Family, b []byte) (*TimeExceeded, error) {
+	data, exts, err := parseErrorBody(fam, b)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeExceeded{Data: data, Extensions: exts}, nil
+}
+
+// ParamProb is the body of a parameter problem message.
+type ParamProb struct {
+	// Pointer is the offset of the offending octet. ICMP only has room
+	// for 8 bits.
+	Pointer uint32
+	Data    []byte // leading part of the offending datagram
+	// Extensions are RFC 4884 extension objects, which ICMPv6 parameter
+	// problem messages cannot carry.
+	Extensions []Extension
+}
+
+// Len implements Body.
+func (p *ParamProb) Len(fam Family) int {
+	if fam == FamilyIPv6 {
+		return 4 + len(p.Data)
+	}
+	return errorBodyLen(fam, p.Data, p.Extensions)
+}
+
+// Marshal implements Body.
+func (p *ParamProb) Marshal(fam Family) ([]byte, error) {
+	if fam == FamilyIPv6 {
+		if len(p.Extensions) > 0 {
+			return nil, errors.New("ICMPv6 parameter problem messages cannot carry extensions")
+		}
+		b := make([]byte, 4+len(p.Data))
+		binary.BigEndian.PutUint32(b[0:4], p.Pointer)
+		copy(b[4:], p.Data)
+		return b, nil
+	}
+	if p.Pointer > 0xff {
+		return nil, fmt.Errorf("pointer %d does not fit ICMP", p.Pointer)
+	}
+	b, err := marshalErrorBody(fam, p.Data, p.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	b[0] = byte(p.Pointer)
+	return b, nil
+}
+
+func parseParamProb(fam Family, b []byte) (*ParamProb, error) {
+	if len(b) < 4 {
+		return nil, errShortBody
+	}
+	if fam == FamilyIPv6 {
+		return &ParamProb{Pointer: binary.BigEndian.Uint32(b[0:4]), Data: append([]byte(nil), b[4:]...)}, nil
+	}
+	data, exts, err := parseErrorBody(fam, b)
+	if err != nil {
+		return nil, err
+	}
+	return &ParamProb{Pointer: uint32(b[0]), Data: data, Extensions: exts}, nil
+}
+
+// PacketTooBig is the body of an ICMPv6 packet too big message.
+type PacketTooBig struct {
+	MTU  uint32 // next-hop MTU
+	Data []byte // leading part of the datagram that was too big
+}
+
+// Len implements Body.
+func (p *PacketTooBig) Len(Family) int { return 4 + len(p.Data) }
+
+// Marshal implements Body.
+func (p *PacketTooBig) Marshal(Family) ([]byte, error) {
+	b := make([]byte, 4+len(p.Data))
+	binary.BigEndian.PutUint32(b[0:4], p.MTU)
+	copy(b[4:], p.Data)
+	return b, nil
+}
+
+func parsePacketTooBig(b []byte) (*PacketTooBig, error) {
+	if len(b) < 4 {
+		return nil, errShortBody
+	}
+	return &PacketTooBig{MTU: binary.BigEndian.Uint32(b[0:4]), Data: append([]byte(nil), b[4:]...)}, nil
+}
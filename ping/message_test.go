@@ -0,0 +1,191 @@
+package ping
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessage_EchoRoundTrip(t *testing.T) {
+	m := Message{Type: TypeEchoRequest, Body: &Echo{ID: 0x1234, Seq: 7, Data: []byte("payload")}}
+	b, err := m.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidateChecksum(b) {
+		t.Error("bad ICMP checksum")
+	}
+	typ, _, id, seq, payload, err := ParsePacket(b)
+	if err != nil || typ != TypeEchoRequest || id != 0x1234 || seq != 7 || string(payload) != "payload" {
+		t.Errorf("ParsePacket disagrees: %d %d %d %q %v", typ, id, seq, payload, err)
+	}
+
+	got, err := ParseMessage(FamilyIPv4, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Body, m.Body) {
+		t.Errorf("got %+v, want %+v", got.Body, m.Body)
+	}
+
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	m6 := Message{Family: FamilyIPv6, Type: TypeEchoReplyV6, Body: &Echo{ID: 1, Seq: 2}}
+	b, err = m6.Marshal(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidateChecksumV6(src, dst, b) {
+		t.Error("bad ICMPv6 checksum")
+	}
+	if got, err := ParseMessage(FamilyIPv6, b); err != nil || got.Type != TypeEchoReplyV6 || got.Body.(*Echo).Seq != 2 {
+		t.Errorf("unexpected message %+v: %v", got, err)
+	}
+}
+
+func TestMessage_Timestamp(t *testing.T) {
+	m := Message{Type: TypeTimestampReply, Body: &TimestampBody{ID: 1, Seq: 2, Originate: 3, Receive: 4, Transmit: 5}}
+	b, err := m.Marshal(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, seq, o, r, x, err := ParseTimestamp(b)
+	if err != nil || id != 1 || seq != 2 || o != 3 || r != 4 || x != 5 {
+		t.Errorf("ParseTimestamp disagrees: %d %d %d %d %d %v", id, seq, o, r, x, err)
+	}
+}
+
+func TestMessage_Extensions(t *testing.T) {
+	quoted := marshalEchoRequest(1, 2, []byte("abc"))
+	exts := []Extension{
+		&MPLSLabelStack{Labels: []MPLSLabel{{Label: 16, S: true, TTL: 1}}},
+		&InterfaceInfo{Role: RoleIncoming, Index: 3, Addr: net.ParseIP("192.0.2.9").To4(), Name: "ge-0/0/1", MTU: 1500},
+		&RawExtension{Class: 200, Type: 1, Data: []byte{1, 2, 3, 4}},
+	}
+	for _, c := range []struct {
+		fam  Family
+		typ  uint8
+		body Body
+		unit int
+	}{
+		{FamilyIPv4, TypeTimeExceeded, &TimeExceeded{Data: quoted, Extensions: exts}, 4},
+		{FamilyIPv4, TypeDestinationUnreachable, &DstUnreach{MTU: 1400, Data: quoted, Extensions: exts}, 4},
+		{FamilyIPv4, TypeParameterProblem, &ParamProb{Pointer: 9, Data: quoted, Extensions: exts}, 4},
+		{FamilyIPv6, TypeTimeExceededV6, &TimeExceeded{Data: quoted, Extensions: exts}, 8},
+	} {
+		m := Message{Family: c.fam, Type: c.typ, Body: c.body}
+		b, err := m.Marshal(net.IPv6loopback, net.IPv6loopback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != 4+c.body.Len(c.fam) {
+			t.Errorf("%v type %d: Len %d disagrees with %d marshalled bytes", c.fam, c.typ, c.body.Len(c.fam), len(b)-4)
+		}
+		length := int(b[5])
+		if c.fam == FamilyIPv6 {
+			length = int(b[4])
+		}
+		if length*c.unit != extensionMinOriginal {
+			t.Errorf("%v type %d: expected the original datagram padded to 128 bytes, length field %d", c.fam, c.typ, length)
+		}
+
+		got, err := ParseMessage(c.fam, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		var gotExts []Extension
+		switch body := got.Body.(type) {
+		case *TimeExceeded:
+			data, gotExts = body.Data, body.Extensions
+		case *DstUnreach:
+			data, gotExts = body.Data, body.Extensions
+			if body.MTU != 1400 {
+				t.Errorf("expected MTU 1400, got %d", body.MTU)
+			}
+		case *ParamProb:
+			data, gotExts = body.Data, body.Extensions
+			if body.Pointer != 9 {
+				t.Errorf("expected pointer 9, got %d", body.Pointer)
+			}
+		default:
+			t.Fatalf("unexpected body %T", got.Body)
+		}
+		if len(data) != extensionMinOriginal || !bytes.HasPrefix(data, quoted) {
+			t.Errorf("%v type %d: unexpected original datagram %x", c.fam, c.typ, data)
+		}
+		if !reflect.DeepEqual(gotExts, exts) {
+			t.Errorf("%v type %d: got extensions %+v, want %+v", c.fam, c.typ, gotExts, exts)
+		}
+	}
+}
+
+func TestMessage_MPLSWireFormat(t *testing.T) {
+	b, err := (&MPLSLabelStack{Labels: []MPLSLabel{{Label: 16, TC: 5, S: true, TTL: 1}}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Length 8, class 1, C-Type 1, then label 16, TC 5, S, TTL 1.
+	want := []byte{0, 8, 1, 1, 0x00, 0x01, 0x0b, 0x01}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got %x, want %x", b, want)
+	}
+}
+
+func TestMessage_LegacyExtensions(t *testing.T) {
+	// A router predating RFC 4884 appends extensions after exactly 128
+	// bytes of original datagram without setting the length field.
+	m := Message{Type: TypeTimeExceeded, Body: &TimeExceeded{
+		Data:       make([]byte, 128),
+		Extensions: []Extension{&MPLSLabelStack{Labels: []MPLSLabel{{Label: 100, S: true, TTL: 254}}}},
+	}}
+	b, _ := m.Marshal(nil, nil)
+	b[5] = 0
+
+	got, err := ParseMessage(FamilyIPv4, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := got.Body.(*TimeExceeded)
+	if len(te.Data) != 128 || len(te.Extensions) != 1 {
+		t.Errorf("expected 128 bytes of datagram and an extension, got %d bytes and %+v", len(te.Data), te.Extensions)
+	}
+
+	// Without a valid extension header the whole body is original datagram.
+	b[4+4+128+2] ^= 0xff
+	got, err = ParseMessage(FamilyIPv4, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te := got.Body.(*TimeExceeded); len(te.Extensions) != 0 || len(te.Data) != len(b)-8 {
+		t.Errorf("expected no extensions, got %+v", te.Extensions)
+	}
+}
+
+func TestMessage_Errors(t *testing.T) {
+	if _, err := ParseMessage(FamilyIPv4, []byte{TypeEchoReply, 0, 0, 0, 1}); err == nil {
+		t.Error("expected error for a truncated echo body")
+	}
+	m := Message{Type: TypeTimeExceeded, Body: &TimeExceeded{Extensions: []Extension{&MPLSLabelStack{}}}}
+	b, _ := m.Marshal(nil, nil)
+	b[len(b)-1] ^= 0xff // corrupt the extension checksum
+	if _, err := ParseMessage(FamilyIPv4, b); err == nil {
+		t.Error("expected error for a bad extension checksum")
+	}
+	if _, err := (&Message{Family: FamilyIPv6, Type: TypeParameterProblemV6, Body: &ParamProb{Extensions: []Extension{&MPLSLabelStack{}}}}).Marshal(nil, nil); err == nil {
+		t.Error("expected error for extensions on an ICMPv6 parameter problem")
+	}
+	if _, err := (&InterfaceInfo{Name: string(make([]byte, 64))}).Marshal(); err == nil {
+		t.Error("expected error for a long interface name")
+	}
+}
+
+func TestMessage_RawBody(t *testing.T) {
+	got, err := ParseMessage(FamilyIPv4, []byte{42, 1, 0, 0, 9, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, ok := got.Body.(*RawBody); !ok || !bytes.Equal(raw.Data, []byte{9, 9}) {
+		t.Errorf("unexpected body %+v", got.Body)
+	}
+}
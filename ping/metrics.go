@@ -0,0 +1,215 @@
+package ping
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsWindow is the default number of recent results behind the
+// loss ratio and RTT quantiles of an Exporter.
+const DefaultMetricsWindow = 100
+
+// Exporter monitors targets and serves their metrics over HTTP in the
+// Prometheus text exposition format:
+//
+//	ping_up                              1 when the target is up, 0 when down
+//	ping_loss_ratio                      lost share of the recent probes
+//	ping_rtt_quantile_seconds            RTT quantiles of the recent replies
+//	ping_jitter_seconds                  RFC 3550 jitter of the recent replies
+//	ping_rtt_seconds                     histogram of every RTT
+//	ping_last_success_timestamp_seconds  Unix time of the last reply
+//	ping_probes_total, ping_replies_total
+//
+// Every series carries a target label, as named in the results. ping_up
+// follows the Monitor's debounced state and is absent while it is unknown.
+// Configure the embedded Monitor before calling Run; Exporter sets its
+// OnResult hook.
+type Exporter struct {
+	*Monitor
+	MetricsWindow int // recent results per target; <= 0 means DefaultMetricsWindow
+
+	mu      sync.Mutex
+	metrics map[string]*targetMetrics
+}
+
+// targetMetrics is the per-target bookkeeping of an Exporter.
+type targetMetrics struct {
+	recent      []Result
+	hist        *Histogram
+	probes      uint64
+	replies     uint64
+	lastSuccess time.Time
+}
+
+// NewExporter returns an Exporter for the given targets with default
+// settings.
+func NewExporter(targets ...string) *Exporter {
+	e := &Exporter{Monitor: NewMonitor(targets...), MetricsWindow: DefaultMetricsWindow, metrics: make(map[string]*targetMetrics)}
+	e.OnResult = func(r Result) { e.record(r, time.Now()) }
+	return e
+}
+
+// Run starts probing in the background. It is RunContext without a
+// context.
+func (e *Exporter) Run() error {
+	return e.RunContext(context.Background())
+}
+
+// RunContext starts probing in the background until Stop is called or ctx
+// is done. Metrics are served whether or not the Exporter runs.
+func (e *Exporter) RunContext(ctx context.Context) error {
+	events, err := e.Monitor.RunContext(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range events {
+		}
+	}()
+	return nil
+}
+
+// record adds a probe result to its target's metrics.
+func (e *Exporter) record(r Result, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tm, ok := e.metrics[r.IP]
+	if !ok {
+		tm = &targetMetrics{hist: NewHistogram(DefaultRTTBuckets...)}
+		e.metrics[r.IP] = tm
+	}
+	size := e.MetricsWindow
+	if size <= 0 {
+		size = DefaultMetricsWindow
+	}
+	tm.recent = append(tm.recent, r)
+	if len(tm.recent) > size {
+		tm.recent = tm.recent[len(tm.recent)-size:]
+	}
+	tm.probes++
+	if r.Success {
+		tm.replies++
+		tm.hist.Add(r.RTT)
+		tm.lastSuccess = now
+	}
+}
+
+// ServeHTTP writes the metrics of every target probed so far.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	e.WriteMetrics(bw)
+	bw.Flush()
+}
+
+// metricSample is one line of a metric family.
+type metricSample struct {
+	suffix string // appended to the family name, such as "_bucket"
+	labels string // rendered labels, without braces
+	value  float64
+}
+
+// WriteMetrics writes the metrics of every target probed so far to w in
+// the Prometheus text exposition format.
+func (e *Exporter) WriteMetrics(w io.Writer) {
+	type family struct {
+		name, typ, help string
+		samples         []metricSample
+	}
+	families := []*family{
+		{name: "ping_up", typ: "gauge", help: "Whether the target is up (1) or down (0)."},
+		{name: "ping_loss_ratio", typ: "gauge", help: "Share of the recent probes that got no reply."},
+		{name: "ping_rtt_quantile_seconds", typ: "gauge", help: "Round trip time quantiles of the recent replies."},
+		{name: "ping_jitter_seconds", typ: "gauge", help: "RFC 3550 interarrival jitter of the recent replies."},
+		{name: "ping_rtt_seconds", typ: "histogram", help: "Round trip times of all replies."},
+		{name: "ping_last_success_timestamp_seconds", typ: "gauge", help: "Unix time of the last reply."},
+		{name: "ping_probes_total", typ: "counter", help: "Probes sent."},
+		{name: "ping_replies_total", typ: "counter", help: "Probes answered."},
+	}
+	up, loss, quantile, jitter, rtt, last, probes, replies := families[0], families[1], families[2], families[3], families[4], families[5], families[6], families[7]
+
+	e.mu.Lock()
+	targets := make([]string, 0, len(e.metrics))
+	for t := range e.metrics {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	for _, t := range targets {
+		tm := e.metrics[t]
+		l := `target="` + escapeLabel(t) + `"`
+
+		switch e.State(t) {
+		case StateUp:
+			up.samples = append(up.samples, metricSample{labels: l, value: 1})
+		case StateDown:
+			up.samples = append(up.samples, metricSample{labels: l, value: 0})
+		}
+
+		stats := windowStatistics(t, tm.recent)
+		loss.samples = append(loss.samples, metricSample{labels: l, value: float64(stats.Sent-stats.Received) / float64(stats.Sent)})
+		if stats.Received > 0 {
+			for _, q := range []struct {
+				q string
+				v time.Duration
+			}{{"0.5", stats.Latency.P50}, {"0.9", stats.Latency.P90}, {"0.99", stats.Latency.P99}} {
+				quantile.samples = append(quantile.samples, metricSample{labels: l + `,quantile="` + q.q + `"`, value: q.v.Seconds()})
+			}
+			jitter.samples = append(jitter.samples, metricSample{labels: l, value: stats.Latency.Jitter.Seconds()})
+		}
+
+		var cumulative uint64
+		for i, b := range tm.hist.Bounds {
+			cumulative += tm.hist.Counts[i]
+			rtt.samples = append(rtt.samples, metricSample{suffix: "_bucket", labels: l + `,le="` + formatFloat(b.Seconds()) + `"`, value: float64(cumulative)})
+		}
+		rtt.samples = append(rtt.samples,
+			metricSample{suffix: "_bucket", labels: l + `,le="+Inf"`, value: float64(tm.hist.Count)},
+			metricSample{suffix: "_sum", labels: l, value: tm.hist.Sum.Seconds()},
+			metricSample{suffix: "_count", labels: l, value: float64(tm.hist.Count)})
+
+		if !tm.lastSuccess.IsZero() {
+			last.samples = append(last.samples, metricSample{labels: l, value: float64(tm.lastSuccess.UnixNano()) / 1e9})
+		}
+		probes.samples = append(probes.samples, metricSample{labels: l, value: float64(tm.probes)})
+		replies.samples = append(replies.samples, metricSample{labels: l, value: float64(tm.replies)})
+	}
+	e.mu.Unlock()
+
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintf(w, "%s%s{%s} %s\n", f.name, s.suffix, s.labels, formatFloat(s.value))
+		}
+	}
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat formats a sample value as Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,132 @@
+package ping
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExporter_WriteMetrics(t *testing.T) {
+	e := NewExporter("h")
+	e.UpAfter = 2
+	now := time.Unix(1700000000, 0)
+	for _, r := range []Result{
+		{IP: "h", Success: true, RTT: time.Millisecond},
+		{IP: "h", Success: true, RTT: 2 * time.Millisecond},
+		{IP: "h"},
+		{IP: "h", Success: true, RTT: 3 * time.Millisecond},
+	} {
+		e.record(r, now)
+		e.Monitor.record(r, now)
+	}
+
+	var buf bytes.Buffer
+	e.WriteMetrics(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE ping_up gauge\nping_up{target=\"h\"} 1\n",
+		"ping_loss_ratio{target=\"h\"} 0.25\n",
+		"ping_rtt_quantile_seconds{target=\"h\",quantile=\"0.5\"} 0.002\n",
+		"# TYPE ping_rtt_seconds histogram\n",
+		"ping_rtt_seconds_bucket{target=\"h\",le=\"0.001\"} 1\n",
+		"ping_rtt_seconds_bucket{target=\"h\",le=\"0.0025\"} 2\n",
+		"ping_rtt_seconds_bucket{target=\"h\",le=\"+Inf\"} 3\n",
+		"ping_rtt_seconds_sum{target=\"h\"} 0.006\n",
+		"ping_rtt_seconds_count{target=\"h\"} 3\n",
+		"ping_last_success_timestamp_seconds{target=\"h\"} 1.7e+09\n",
+		"ping_probes_total{target=\"h\"} 4\n",
+		"ping_replies_total{target=\"h\"} 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestExporter_UnknownAndDownTargets(t *testing.T) {
+	e := NewExporter()
+	e.DownAfter = 1
+	e.record(Result{IP: "new"}, time.Now())
+	e.record(Result{IP: `a"b`}, time.Now())
+	e.Monitor.record(Result{IP: `a"b`}, time.Now())
+
+	var buf bytes.Buffer
+	e.WriteMetrics(&buf)
+	out := buf.String()
+	if strings.Contains(out, `ping_up{target="new"}`) {
+		t.Error("ping_up reported for a target in unknown state")
+	}
+	if !strings.Contains(out, `ping_up{target="a\"b"} 0`) {
+		t.Errorf("expected escaped down target in:\n%s", out)
+	}
+	if strings.Contains(out, "ping_last_success_timestamp_seconds") || strings.Contains(out, "ping_rtt_quantile_seconds") {
+		t.Errorf("unexpected reply metrics for unanswered targets:\n%s", out)
+	}
+}
+
+// scrape returns the body served by e.
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// waitUp scrapes e until target is reported up.
+func waitUp(t *testing.T, e *Exporter, target string) string {
+	t.Helper()
+	want := `ping_up{target="` + target + `"} 1`
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		body := scrape(t, e)
+		if strings.Contains(body, want) {
+			return body
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never came up:\n%s", target, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExporter_FakeServe(t *testing.T) {
+	e := NewExporter("192.0.2.1")
+	e.Interval = 10 * time.Millisecond
+	e.Timeout = 50 * time.Millisecond
+	e.Listen = listenEcho()
+	if err := e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+
+	body := waitUp(t, e, "192.0.2.1")
+	if !strings.Contains(body, `ping_loss_ratio{target="192.0.2.1"} 0`) {
+		t.Errorf("expected no loss in:\n%s", body)
+	}
+}
+
+// TestExporter_Loopback is skipped when loopback cannot be pinged.
+func TestExporter_Loopback(t *testing.T) {
+	if r := Ping("127.0.0.1", time.Second); !r.Success {
+		t.Skipf("cannot ping loopback: %v", r.Err)
+	}
+	e := NewExporter("127.0.0.1")
+	e.Interval = 10 * time.Millisecond
+	if err := e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	waitUp(t, e, "127.0.0.1")
+}
@@ -0,0 +1,240 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default thresholds used by NewMonitor.
+const (
+	DefaultDownAfter = 3
+	DefaultUpAfter   = 2
+	DefaultWindow    = 10
+)
+
+// State is the reachability of a monitored target.
+type State int
+
+const (
+	// StateUnknown is the state before enough probes settled either way.
+	StateUnknown State = iota
+	StateUp
+	StateDown
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUp:
+		return "up"
+	case StateDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// StateChange is emitted by a Monitor whenever a target changes state.
+type StateChange struct {
+	Target string
+	From   State
+	To     State
+	At     time.Time
+	Window *Statistics // the most recent results of the target
+}
+
+// Monitor pings a set of targets on a schedule and reports reachability
+// transitions. A target goes down after DownAfter consecutive failed probes
+// and up after UpAfter consecutive successes, so a single lost packet does
+// not make it flap. Configure the exported fields before calling Run.
+type Monitor struct {
+	Options
+	Targets     []string      // host names, addresses or CIDR blocks
+	Interval    time.Duration // delay between two probe rounds
+	DownAfter   int           // consecutive failures before a target is down
+	UpAfter     int           // consecutive successes before a target is up
+	Window      int           // recent results attached to each StateChange
+	MaxInFlight int           // bound on outstanding probes per round
+
+	// OnResult, when set, is called with the outcome of every probe. It
+	// runs on the monitoring goroutine and should return quickly.
+	OnResult func(Result)
+
+	mu      sync.Mutex
+	targets map[string]*targetState
+	ctl     runControl
+}
+
+// targetState is the per-target bookkeeping of a Monitor.
+type targetState struct {
+	state     State
+	successes int // consecutive
+	failures  int // consecutive
+	window    []Result
+}
+
+// NewMonitor returns a Monitor for the given targets with default settings.
+func NewMonitor(targets ...string) *Monitor {
+	return &Monitor{
+		Options:     Options{Timeout: DefaultTimeout},
+		Targets:     targets,
+		Interval:    DefaultInterval,
+		DownAfter:   DefaultDownAfter,
+		UpAfter:     DefaultUpAfter,
+		Window:      DefaultWindow,
+		MaxInFlight: DefaultMaxInFlight,
+	}
+}
+
+// Stop ends monitoring right away, cancelling the probe round in progress.
+// It is safe to call Stop more than once and from any goroutine.
+func (m *Monitor) Stop() {
+	m.ctl.halt()
+}
+
+// State returns the current state of target, as named in the results.
+func (m *Monitor) State(target string) State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ts, ok := m.targets[target]; ok {
+		return ts.state
+	}
+	return StateUnknown
+}
+
+// Run starts monitoring in the background and returns the channel on which
+// state changes are delivered. The first transition out of StateUnknown is
+// reported as well. Monitoring pauses while the consumer does not keep up;
+// the channel is closed once Stop is called.
+func (m *Monitor) Run() (<-chan StateChange, error) {
+	return m.RunContext(context.Background())
+}
+
+// RunContext is like Run but also stops monitoring when ctx is done.
+func (m *Monitor) RunContext(ctx context.Context) (<-chan StateChange, error) {
+	if m.Interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	if m.DownAfter <= 0 || m.UpAfter <= 0 {
+		return nil, errors.New("thresholds must be positive")
+	}
+
+	// Validate the targets once up front, as every round repeats the sweep.
+	if _, err := m.sweeper().parse(); err != nil {
+		return nil, err
+	}
+
+	events := make(chan StateChange, len(m.Targets))
+	go m.run(ctx, events)
+	return events, nil
+}
+
+// sweeper returns a Sweeper for one probe round.
+func (m *Monitor) sweeper() *Sweeper {
+	s := NewSweeper(m.Targets...)
+	s.Options = m.Options
+	s.MaxInFlight = m.MaxInFlight
+	return s
+}
+
+func (m *Monitor) run(ctx context.Context, events chan<- StateChange) {
+	defer close(events)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.ctl.stopped():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := m.sweeper().RunContext(ctx)
+		if err != nil {
+			return
+		}
+		for r := range results {
+			if m.OnResult != nil {
+				m.OnResult(r)
+			}
+			if change, ok := m.record(r, time.Now()); ok {
+				select {
+				case events <- change:
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record adds a probe result to its target's history and reports the state
+// change it causes, if any.
+func (m *Monitor) record(r Result, now time.Time) (StateChange, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts, ok := m.targets[r.IP]
+	if !ok {
+		if m.targets == nil {
+			m.targets = make(map[string]*targetState)
+		}
+		ts = &targetState{}
+		m.targets[r.IP] = ts
+	}
+
+	size := m.Window
+	if size <= 0 {
+		size = DefaultWindow
+	}
+	ts.window = append(ts.window, r)
+	if len(ts.window) > size {
+		ts.window = ts.window[len(ts.window)-size:]
+	}
+
+	if r.Success {
+		ts.successes++
+		ts.failures = 0
+	} else {
+		ts.failures++
+		ts.successes = 0
+	}
+
+	next := ts.state
+	switch {
+	case ts.state != StateUp && ts.successes >= m.UpAfter:
+		next = StateUp
+	case ts.state != StateDown && ts.failures >= m.DownAfter:
+		next = StateDown
+	}
+	if next == ts.state {
+		return StateChange{}, false
+	}
+
+	change := StateChange{Target: r.IP, From: ts.state, To: next, At: now, Window: windowStatistics(r.IP, ts.window)}
+	ts.state = next
+	return change, true
+}
+
+// windowStatistics summarises a copy of the given results.
+func windowStatistics(target string, results []Result) *Statistics {
+	s := &Statistics{
+		Target:  target,
+		IP:      target,
+		Sent:    len(results),
+		Results: append([]Result(nil), results...),
+	}
+	s.summarize()
+	return s
+}
@@ -0,0 +1,97 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitorRecord_Thresholds(t *testing.T) {
+	m := NewMonitor("192.0.2.1")
+	m.UpAfter = 2
+	m.DownAfter = 3
+	m.Window = 4
+
+	steps := []struct {
+		success bool
+		want    State
+		changed bool
+	}{
+		{true, StateUnknown, false},
+		{true, StateUp, true},
+		{false, StateUp, false},
+		{false, StateUp, false},
+		{true, StateUp, false}, // success resets the failure run
+		{false, StateUp, false},
+		{false, StateUp, false},
+		{false, StateDown, true},
+		{true, StateDown, false},
+		{true, StateUp, true},
+	}
+	for i, s := range steps {
+		change, changed := m.record(Result{IP: "192.0.2.1", Success: s.success}, time.Now())
+		if changed != s.changed {
+			t.Fatalf("step %d: expected changed=%v", i, s.changed)
+		}
+		if got := m.State("192.0.2.1"); got != s.want {
+			t.Fatalf("step %d: expected %v, got %v", i, s.want, got)
+		}
+		if changed && (change.To != s.want || change.Window.Sent > m.Window) {
+			t.Fatalf("step %d: unexpected change %+v", i, change)
+		}
+	}
+}
+
+func TestMonitorRecord_WindowStatistics(t *testing.T) {
+	m := NewMonitor("h")
+	m.DownAfter = 2
+	m.record(Result{IP: "h", Success: true, RTT: time.Millisecond}, time.Now())
+	m.record(Result{IP: "h"}, time.Now())
+	change, ok := m.record(Result{IP: "h"}, time.Now())
+	if !ok || change.From != StateUnknown || change.To != StateDown {
+		t.Fatalf("unexpected change %+v ok=%v", change, ok)
+	}
+	w := change.Window
+	if w.Sent != 3 || w.Received != 1 || w.AvgRTT != time.Millisecond {
+		t.Errorf("unexpected window %+v", w)
+	}
+}
+
+func TestMonitor_RejectsBadSettings(t *testing.T) {
+	m := NewMonitor("127.0.0.1")
+	m.Interval = 0
+	if _, err := m.Run(); err == nil {
+		t.Error("expected error for zero interval")
+	}
+	if _, err := NewMonitor("10.0.0.0/99").Run(); err == nil {
+		t.Error("expected error for invalid target")
+	}
+}
+
+func TestMonitor_LoopbackGoesUp(t *testing.T) {
+	if c, err := listen(FamilyIPv4, SocketAuto, nil); err != nil {
+		t.Skipf("cannot open ICMP socket: %v", err)
+	} else {
+		c.Close()
+	}
+
+	m := NewMonitor("127.0.0.1")
+	m.Interval = 10 * time.Millisecond
+	events, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	select {
+	case change := <-events:
+		if change.Target != "127.0.0.1" || change.To != StateUp {
+			t.Errorf("unexpected change %+v", change)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no state change reported")
+	}
+
+	m.Stop()
+	for range events {
+	}
+}
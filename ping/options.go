@@ -0,0 +1,221 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Family selects the IP version used for probing.
+type Family int
+
+const (
+	// FamilyAuto picks the family from the resolved target address.
+	FamilyAuto Family = iota
+	// FamilyIPv4 forces ICMP over IPv4.
+	FamilyIPv4
+	// FamilyIPv6 forces ICMPv6 over IPv6.
+	FamilyIPv6
+)
+
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	default:
+		return "auto"
+	}
+}
+
+// network is the raw socket network name for the family.
+func (f Family) network() string {
+	if f == FamilyIPv6 {
+		return "ip6:ipv6-icmp"
+	}
+	return "ip4:icmp"
+}
+
+// listenAddr is the wildcard address a socket of the family binds to.
+func (f Family) listenAddr() string {
+	if f == FamilyIPv6 {
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// echoRequest and echoReply return the ICMP types for the family.
+func (f Family) echoRequest() uint8 {
+	if f == FamilyIPv6 {
+		return TypeEchoRequestV6
+	}
+	return TypeEchoRequest
+}
+
+func (f Family) echoReply() uint8 {
+	if f == FamilyIPv6 {
+		return TypeEchoReplyV6
+	}
+	return TypeEchoReply
+}
+
+// timeExceeded and destinationUnreachable return the ICMP error types for
+// the family.
+func (f Family) timeExceeded() uint8 {
+	if f == FamilyIPv6 {
+		return TypeTimeExceededV6
+	}
+	return TypeTimeExceeded
+}
+
+func (f Family) destinationUnreachable() uint8 {
+	if f == FamilyIPv6 {
+		return TypeDestinationUnreachableV6
+	}
+	return TypeDestinationUnreachable
+}
+
+// familyOf reports the family of a resolved address.
+func familyOf(ip net.IP) Family {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// Options controls how individual probes are sent. The zero value selects
+// the address family and socket type automatically; a zero Timeout means
+// DefaultTimeout.
+type Options struct {
+	Family  Family
+	Socket  SocketType
+	Timeout time.Duration // how long to wait for each reply
+
+	// Size is the echo payload size in bytes (ping -s). It is raised to
+	// MinPayloadSize, as every payload starts with a send timestamp and a
+	// per-probe nonce used to verify the echoed data.
+	Size int
+	// Pattern fills the payload after the nonce (ping -p), repeated as
+	// needed. An empty pattern fills it with incrementing byte values.
+	Pattern []byte
+
+	// Source is the local address probes are sent from; nil lets the
+	// kernel choose. With FamilyAuto it also selects the family.
+	Source net.IP
+	// Interface binds probes to the named network interface
+	// (SO_BINDTODEVICE, Linux only), whatever the routing table says.
+	Interface string
+	// TTL sets the TTL (IPv4) or hop limit (IPv6) of outgoing probes; 0
+	// keeps the system default.
+	TTL int
+	// TOS sets the IPv4 type of service byte or IPv6 traffic class of
+	// outgoing probes, with the DSCP in its upper six bits; 0 keeps the
+	// system default.
+	TOS int
+
+	// Fallback lists the methods PingContext tries in turn when an ICMP
+	// probe times out, for hosts behind filters that drop ICMP.
+	Fallback []ProbeMethod
+
+	// Listen, when set, opens the transport for a family instead of an
+	// ICMP socket, and Socket is ignored. It lets tests run the probing
+	// logic without sockets.
+	Listen func(fam Family) (Conn, error)
+
+	// Recorder, when set, records every ICMP message sent and received to
+	// a capture file.
+	Recorder *Recorder
+}
+
+// timeout returns the configured timeout or DefaultTimeout.
+func (o *Options) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DefaultTimeout
+}
+
+// listen opens the transport for fam: an ICMP socket of the configured type,
+// source address and socket options, unless Listen is set. Its traffic is
+// recorded when Recorder is set.
+func (o *Options) listen(fam Family) (Conn, error) {
+	c, err := o.open(fam)
+	if err != nil || o.Recorder == nil {
+		return c, err
+	}
+	return newRecordingConn(c, o.Recorder, o), nil
+}
+
+// open opens the transport for fam as described for listen.
+func (o *Options) open(fam Family) (Conn, error) {
+	if o.Listen != nil {
+		return o.Listen(fam)
+	}
+	if o.TTL < 0 || o.TTL > 255 {
+		return nil, fmt.Errorf("invalid TTL %d", o.TTL)
+	}
+	if o.TOS < 0 || o.TOS > 255 {
+		return nil, fmt.Errorf("invalid TOS %d", o.TOS)
+	}
+	if o.Source != nil && familyOf(o.Source) != fam {
+		return nil, fmt.Errorf("source address %s is not an %s address", o.Source, fam)
+	}
+
+	c, err := listen(fam, o.Socket, o.Source)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apply(o); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// source returns the address probes to dst are sent from, as needed for the
+// ICMPv6 checksum: Source when set, otherwise the kernel's choice.
+func (o *Options) source(dst net.IP) net.IP {
+	if o.Source != nil {
+		return o.Source
+	}
+	return sourceFor(dst)
+}
+
+// resolve looks up target restricted to the configured family and returns
+// the address together with the family that will be used to probe it. Like
+// net.ResolveIPAddr, FamilyAuto prefers an IPv4 address when there is one.
+func (o *Options) resolve(ctx context.Context, target string) (*net.IPAddr, Family, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+	if err != nil {
+		return nil, FamilyAuto, fmt.Errorf("resolve: %w", err)
+	}
+
+	want := o.Family
+	if want == FamilyAuto && o.Source != nil {
+		want = familyOf(o.Source)
+	}
+	if want == FamilyAuto {
+		want = FamilyIPv4
+		if !hasFamily(addrs, FamilyIPv4) {
+			want = FamilyIPv6
+		}
+	}
+	for i := range addrs {
+		if familyOf(addrs[i].IP) == want {
+			return &addrs[i], want, nil
+		}
+	}
+	return nil, FamilyAuto, fmt.Errorf("resolve: no %s address for %s", want, target)
+}
+
+// hasFamily reports whether addrs contains an address of the family.
+func hasFamily(addrs []net.IPAddr, fam Family) bool {
+	for _, a := range addrs {
+		if familyOf(a.IP) == fam {
+			return true
+		}
+	}
+	return false
+}
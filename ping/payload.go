@@ -0,0 +1,66 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// MinPayloadSize is the smallest echo payload sent through Options: an
+// 8-byte send timestamp followed by an 8-byte per-probe nonce.
+const MinPayloadSize = 16
+
+// maxPacketSize bounds every packet read from an ICMP socket.
+const maxPacketSize = 65535
+
+// ErrCorruptPayload is wrapped by Result.Err when a reply arrived but did not
+// echo the request payload byte for byte, or failed its checksum.
+var ErrCorruptPayload = errors.New("corrupt reply payload")
+
+// payload builds the echo payload of one probe: the send timestamp, a random
+// nonce and the fill pattern repeated up to the configured size.
+func (o *Options) payload(ts time.Time) []byte {
+	size := o.Size
+	if size < MinPayloadSize {
+		size = MinPayloadSize
+	}
+	p := make([]byte, size)
+	binary.BigEndian.PutUint64(p[0:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint64(p[8:16], rand.Uint64())
+
+	fill := p[MinPayloadSize:]
+	if len(o.Pattern) == 0 {
+		// Like ping(8), count up from the first byte after the header data.
+		for i := range fill {
+			fill[i] = byte(MinPayloadSize + i)
+		}
+		return p
+	}
+	for i := range fill {
+		fill[i] = o.Pattern[i%len(o.Pattern)]
+	}
+	return p
+}
+
+// payloadTime returns the send time embedded at the start of a payload.
+func payloadTime(p []byte) (time.Time, bool) {
+	if len(p) < 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(p))), true
+}
+
+// verifyPayload checks that got is an exact echo of the payload sent.
+func verifyPayload(sent, got []byte) error {
+	if len(got) != len(sent) {
+		return fmt.Errorf("%w: %d bytes echoed, %d sent", ErrCorruptPayload, len(got), len(sent))
+	}
+	for i := range sent {
+		if got[i] != sent[i] {
+			return fmt.Errorf("%w: mismatch at byte %d", ErrCorruptPayload, i)
+		}
+	}
+	return nil
+}
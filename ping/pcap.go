@@ -0,0 +1,608 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"net"
+	"sync"
+	"time"
+)
+
+// CaptureFormat selects the file format written by a Recorder.
+type CaptureFormat int
+
+const (
+	// FormatPcap is the classic libpcap format with nanosecond timestamps.
+	FormatPcap CaptureFormat = iota
+	// FormatPcapng is the pcap next generation format.
+	FormatPcapng
+)
+
+func (f CaptureFormat) String() string {
+	if f == FormatPcapng {
+		return "pcapng"
+	}
+	return "pcap"
+}
+
+// Capture file constants. Packets are stored as bare IPv4 or IPv6 datagrams
+// (LINKTYPE_RAW), so that both families share one file.
+const (
+	linkTypeRaw      = 101
+	captureSnapLen   = 65535
+	pcapMagicNano    = 0xa1b23c4d
+	pcapMagicMicro   = 0xa1b2c3d4
+	pcapngSHB        = 0x0a0d0d0a
+	pcapngIDB        = 1
+	pcapngSPB        = 3
+	pcapngEPB        = 6
+	pcapngByteOrder  = 0x1a2b3c4d
+	pcapngOptTSResol = 9
+)
+
+// defaultTTL is recorded for outgoing probes when Options.TTL leaves the
+// system default in place.
+const defaultTTL = 64
+
+// Recorder writes the ICMP messages exchanged by probes to a capture file
+// that tools such as Wireshark and tcpdump can read. Set it in
+// Options.Recorder. Sockets do not hand out the IP header of what they send,
+// nor reliably of what they receive, so the recorder reconstructs a minimal
+// one from the addresses, TTL and TOS; messages are recorded as they were
+// written to or read from the socket. A Recorder may be shared by any
+// number of probes.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format CaptureFormat
+	err    error
+}
+
+// NewRecorder writes the file header of the given format to w and returns
+// a Recorder appending packets to it. Wrap w in a bufio.Writer for busy
+// captures, and flush it once probing is done.
+func NewRecorder(w io.Writer, format CaptureFormat) (*Recorder, error) {
+	r := &Recorder{w: w, format: format}
+	var hdr []byte
+	if format == FormatPcapng {
+		// Section header block, then the one interface all packets use,
+		// with nanosecond timestamps.
+		hdr = make([]byte, 28+32)
+		le.PutUint32(hdr[0:], pcapngSHB)
+		le.PutUint32(hdr[4:], 28)
+		le.PutUint32(hdr[8:], pcapngByteOrder)
+		le.PutUint16(hdr[12:], 1)
+		le.PutUint64(hdr[16:], ^uint64(0)) // section length unknown
+		le.PutUint32(hdr[24:], 28)
+
+		idb := hdr[28:]
+		le.PutUint32(idb[0:], pcapngIDB)
+		le.PutUint32(idb[4:], 32)
+		le.PutUint16(idb[8:], linkTypeRaw)
+		le.PutUint32(idb[12:], captureSnapLen)
+		le.PutUint16(idb[16:], pcapngOptTSResol)
+		le.PutUint16(idb[18:], 1)
+		idb[20] = 9
+		// idb[24:28] is the end of options.
+		le.PutUint32(idb[28:], 32)
+	} else {
+		hdr = make([]byte, 24)
+		le.PutUint32(hdr[0:], pcapMagicNano)
+		le.PutUint16(hdr[4:], 2)
+		le.PutUint16(hdr[6:], 4)
+		le.PutUint32(hdr[16:], captureSnapLen)
+		le.PutUint32(hdr[20:], linkTypeRaw)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// le is the byte order of the files written by Recorder.
+var le = binary.LittleEndian
+
+// Err returns the first error met writing the capture. Recording stops at
+// that error; probing goes on.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// record writes the ICMP message msg sent from src to dst at at.
+func (r *Recorder) record(at time.Time, src, dst net.IP, ttl, tos int, msg []byte) {
+	pkt := ipPacket(src, dst, ttl, tos, msg)
+	ns := uint64(at.UnixNano())
+
+	var rec []byte
+	if r.format == FormatPcapng {
+		pad := (4 - len(pkt)%4) % 4
+		n := 32 + len(pkt) + pad
+		rec = make([]byte, n)
+		le.PutUint32(rec[0:], pcapngEPB)
+		le.PutUint32(rec[4:], uint32(n))
+		le.PutUint32(rec[12:], uint32(ns>>32))
+		le.PutUint32(rec[16:], uint32(ns))
+		le.PutUint32(rec[20:], uint32(len(pkt)))
+		le.PutUint32(rec[24:], uint32(len(pkt)))
+		copy(rec[28:], pkt)
+		le.PutUint32(rec[n-4:], uint32(n))
+	} else {
+		rec = make([]byte, 16+len(pkt))
+		le.PutUint32(rec[0:], uint32(ns/1e9))
+		le.PutUint32(rec[4:], uint32(ns%1e9))
+		le.PutUint32(rec[8:], uint32(len(pkt)))
+		le.PutUint32(rec[12:], uint32(len(pkt)))
+		copy(rec[16:], pkt)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		_, r.err = r.w.Write(rec)
+	}
+}
+
+// ipPacket prepends an IPv4 or IPv6 header carrying msg from src to dst. A
+// nil address is recorded as the unspecified address of the other's family.
+func ipPacket(src, dst net.IP, ttl, tos int, msg []byte) []byte {
+	v4 := dst.To4() != nil || (dst == nil && src.To4() != nil)
+	if v4 {
+		pkt := make([]byte, 20+len(msg))
+		pkt[0] = 0x45
+		pkt[1] = byte(tos)
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		pkt[8] = byte(ttl)
+		pkt[9] = protoICMP
+		copy(pkt[12:16], src.To4())
+		copy(pkt[16:20], dst.To4())
+		binary.BigEndian.PutUint16(pkt[10:], ipChecksum(pkt[:20]))
+		copy(pkt[20:], msg)
+		return pkt
+	}
+	pkt := make([]byte, 40+len(msg))
+	binary.BigEndian.PutUint32(pkt[0:], 6<<28|uint32(tos)<<20)
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(msg)))
+	pkt[6] = protoICMPv6
+	pkt[7] = byte(ttl)
+	copy(pkt[8:24], src.To16())
+	copy(pkt[24:40], dst.To16())
+	copy(pkt[40:], msg)
+	return pkt
+}
+
+// recordingConn records the messages exchanged over a Conn.
+type recordingConn struct {
+	Conn
+	rec *Recorder
+	tos int
+	src net.IP // configured source address; nil lets the kernel choose
+
+	mu    sync.Mutex
+	ttl   int               // TTL of outgoing probes
+	local map[string]net.IP // kernel's source address per peer
+}
+
+func newRecordingConn(c Conn, rec *Recorder, o *Options) *recordingConn {
+	ttl := o.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	return &recordingConn{Conn: c, rec: rec, ttl: ttl, tos: o.TOS, src: o.Source, local: make(map[string]net.IP)}
+}
+
+// localFor returns the local address of the exchange with peer.
+func (c *recordingConn) localFor(peer net.IP) net.IP {
+	if c.src != nil {
+		return c.src
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(peer.To16())
+	ip, ok := c.local[key]
+	if !ok {
+		ip = sourceFor(peer)
+		c.local[key] = ip
+	}
+	return ip
+}
+
+// setTTL sets the TTL of the underlying socket, which socketOptions checked
+// to support it, and records it for the packets that follow.
+func (c *recordingConn) setTTL(ttl int) error {
+	if err := c.Conn.(sockoptConn).setTTL(ttl); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *recordingConn) setDontFragment() error {
+	return c.Conn.(sockoptConn).setDontFragment()
+}
+
+func (c *recordingConn) WriteTo(b []byte, dst *net.IPAddr) (int, error) {
+	n, err := c.Conn.WriteTo(b, dst)
+	if err == nil {
+		c.mu.Lock()
+		ttl := c.ttl
+		c.mu.Unlock()
+		c.rec.record(time.Now(), c.localFor(dst.IP), dst.IP, ttl, c.tos, b)
+	}
+	return n, err
+}
+
+func (c *recordingConn) ReadFrom(b []byte) ([]byte, PacketInfo, error) {
+	msg, info, err := c.Conn.ReadFrom(b)
+	if err == nil {
+		at, _ := info.received()
+		c.rec.record(at, info.From, c.localFor(info.From), info.TTL, 0, msg)
+	}
+	return msg, info, err
+}
+
+// CapturedPacket is an ICMP or ICMPv6 message read from a capture file.
+type CapturedPacket struct {
+	At       time.Time
+	Src, Dst net.IP
+	TTL      int    // TTL or hop limit
+	Data     []byte // the ICMP message, without IP header
+}
+
+// Family returns the address family of the packet.
+func (p *CapturedPacket) Family() Family {
+	return familyOf(p.Src)
+}
+
+// Parse decodes the ICMP header of the packet with ParsePacket.
+func (p *CapturedPacket) Parse() (typeByte uint8, code uint8, id uint16, seq uint16, payload []byte, err error) {
+	return ParsePacket(p.Data)
+}
+
+// ErrUnsupportedCapture is returned by CaptureReader for files that are
+// neither pcap nor pcapng, whose link type it cannot decode, or whose
+// records are malformed or larger than it accepts.
+var ErrUnsupportedCapture = errors.New("unsupported capture file")
+
+// CaptureReader reads the ICMP packets of a pcap or pcapng file, such as
+// one written by a Recorder or tcpdump. Packets other than unfragmented
+// ICMP and ICMPv6 are skipped.
+type CaptureReader struct {
+	r     io.Reader
+	ng    bool
+	order binary.ByteOrder
+	nano  bool // pcap timestamps in nanoseconds
+
+	link    int          // pcap link type
+	snapLen uint32       // pcap snapshot length
+	ifaces  []captureIfc // pcapng interfaces of the current section
+}
+
+// captureIfc is an interface described by a pcapng file.
+type captureIfc struct {
+	link int
+	unit time.Duration // timestamp resolution; 0 when finer than 1ns
+	per  uint64        // ticks per second when unit is 0
+}
+
+// Link types CaptureReader decodes besides LINKTYPE_RAW.
+const (
+	linkTypeEthernet = 1
+	linkTypeRawAlt   = 12 // DLT_RAW on some BSDs
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeLinuxSLL = 113
+)
+
+// Limits on the records CaptureReader accepts, so that a corrupt file cannot
+// make it allocate more than this per record.
+const (
+	maxCaptureRecord = 262144 // largest snaplen tcpdump writes
+	maxCaptureBlock  = 1 << 24
+)
+
+// NewCaptureReader reads the file header from r.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	c := &CaptureReader{r: r}
+	if binary.LittleEndian.Uint32(hdr[:]) == pcapngSHB {
+		c.ng = true
+		if err := c.readSection(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	var rest [20]byte
+	if _, err := io.ReadFull(r, rest[:]); err != nil {
+		return nil, err
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[:]) {
+		case pcapMagicNano:
+			c.nano = true
+		case pcapMagicMicro:
+		default:
+			continue
+		}
+		c.order = order
+		c.link = int(order.Uint32(rest[16:]) & 0x0fffffff)
+		c.snapLen = order.Uint32(rest[12:])
+		if c.snapLen == 0 || c.snapLen > maxCaptureRecord {
+			c.snapLen = maxCaptureRecord
+		}
+		return c, nil
+	}
+	return nil, ErrUnsupportedCapture
+}
+
+// readSection reads the rest of a pcapng section header block whose type
+// has been read.
+func (c *CaptureReader) readSection() error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	switch binary.LittleEndian.Uint32(hdr[4:]) {
+	case pcapngByteOrder:
+		c.order = binary.LittleEndian
+	case 0x4d3c2b1a:
+		c.order = binary.BigEndian
+	default:
+		return ErrUnsupportedCapture
+	}
+	n := c.order.Uint32(hdr[:])
+	if n < 28 || n%4 != 0 || n > maxCaptureBlock {
+		return ErrUnsupportedCapture
+	}
+	if _, err := io.CopyN(io.Discard, c.r, int64(n)-12); err != nil {
+		return err
+	}
+	c.ifaces = nil
+	return nil
+}
+
+// Next returns the next ICMP packet, or io.EOF at the end of the file.
+func (c *CaptureReader) Next() (*CapturedPacket, error) {
+	for {
+		at, ifc, frame, err := c.nextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if p := decodeFrame(ifc, frame); p != nil {
+			p.At = at
+			return p, nil
+		}
+	}
+}
+
+// nextFrame returns the next captured frame with its timestamp and the
+// link type it was captured on.
+func (c *CaptureReader) nextFrame() (time.Time, int, []byte, error) {
+	if !c.ng {
+		var hdr [16]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return time.Time{}, 0, nil, err
+		}
+		frac := int64(c.order.Uint32(hdr[4:]))
+		if !c.nano {
+			frac *= 1e3
+		}
+		at := time.Unix(int64(c.order.Uint32(hdr[0:])), frac)
+		caplen := c.order.Uint32(hdr[8:])
+		if caplen > c.snapLen {
+			return time.Time{}, 0, nil, ErrUnsupportedCapture
+		}
+		frame := make([]byte, caplen)
+		if _, err := io.ReadFull(c.r, frame); err != nil {
+			return time.Time{}, 0, nil, unexpectedEOF(err)
+		}
+		return at, c.link, frame, nil
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(c.r, hdr[:4]); err != nil {
+			return time.Time{}, 0, nil, err
+		}
+		// The section header block type reads the same in both byte
+		// orders, and a new section may change the order.
+		if binary.LittleEndian.Uint32(hdr[:4]) == pcapngSHB {
+			if err := c.readSection(); err != nil {
+				return time.Time{}, 0, nil, unexpectedEOF(err)
+			}
+			continue
+		}
+		if _, err := io.ReadFull(c.r, hdr[4:]); err != nil {
+			return time.Time{}, 0, nil, unexpectedEOF(err)
+		}
+		typ, n := c.order.Uint32(hdr[:4]), c.order.Uint32(hdr[4:])
+		if n < 12 || n%4 != 0 || n > maxCaptureBlock {
+			return time.Time{}, 0, nil, ErrUnsupportedCapture
+		}
+		body := make([]byte, n-8)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return time.Time{}, 0, nil, unexpectedEOF(err)
+		}
+		body = body[:len(body)-4] // trailing block length
+
+		switch typ {
+		case pcapngIDB:
+			ifc, err := c.parseInterface(body)
+			if err != nil {
+				return time.Time{}, 0, nil, err
+			}
+			c.ifaces = append(c.ifaces, ifc)
+		case pcapngEPB:
+			if len(body) < 20 {
+				return time.Time{}, 0, nil, ErrUnsupportedCapture
+			}
+			i := int(c.order.Uint32(body[0:]))
+			ts := uint64(c.order.Uint32(body[4:]))<<32 | uint64(c.order.Uint32(body[8:]))
+			caplen := int(c.order.Uint32(body[12:]))
+			if i >= len(c.ifaces) || 20+caplen > len(body) {
+				return time.Time{}, 0, nil, ErrUnsupportedCapture
+			}
+			return c.ifaces[i].time(ts), c.ifaces[i].link, body[20 : 20+caplen], nil
+		case pcapngSPB:
+			if len(c.ifaces) == 0 || len(body) < 4 {
+				return time.Time{}, 0, nil, ErrUnsupportedCapture
+			}
+			caplen := min(int(c.order.Uint32(body[0:])), len(body)-4)
+			return time.Time{}, c.ifaces[0].link, body[4 : 4+caplen], nil
+		}
+	}
+}
+
+// parseInterface decodes an interface description block body.
+func (c *CaptureReader) parseInterface(body []byte) (captureIfc, error) {
+	ifc := captureIfc{unit: time.Microsecond}
+	if len(body) < 8 {
+		return ifc, nil
+	}
+	ifc.link = int(c.order.Uint16(body[0:]))
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code, n := c.order.Uint16(opts[0:]), int(c.order.Uint16(opts[2:]))
+		if code == 0 || 4+n > len(opts) {
+			break
+		}
+		if code == pcapngOptTSResol && n >= 1 {
+			var ok bool
+			if ifc.unit, ifc.per, ok = tsResolution(opts[4]); !ok {
+				return ifc, ErrUnsupportedCapture
+			}
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+	return ifc, nil
+}
+
+// tsResolution decodes an if_tsresol value: a negative power of ten, or of
+// two when the high bit is set. It fails for resolutions whose ticks per
+// second do not fit in a uint64.
+func tsResolution(v byte) (unit time.Duration, per uint64, ok bool) {
+	if v&0x80 != 0 {
+		if v&0x7f > 63 {
+			return 0, 0, false
+		}
+		return 0, 1 << (v & 0x7f), true
+	}
+	if v > 19 {
+		return 0, 0, false
+	}
+	per = 1
+	for i := byte(0); i < v; i++ {
+		per *= 10
+	}
+	if v <= 9 {
+		return time.Second / time.Duration(per), per, true
+	}
+	return 0, per, true
+}
+
+// time converts a timestamp in the units of the interface.
+func (ifc captureIfc) time(ts uint64) time.Time {
+	if ifc.unit > 0 {
+		return time.Unix(0, int64(ts)*int64(ifc.unit))
+	}
+	sec := ts / ifc.per
+	// frac < per, so the 128-bit quotient fits in 64 bits.
+	hi, lo := bits.Mul64(ts%ifc.per, uint64(time.Second))
+	ns, _ := bits.Div64(hi, lo, ifc.per)
+	return time.Unix(int64(sec), int64(ns))
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decodeFrame extracts the ICMP or ICMPv6 message of a frame, or returns
+// nil if it carries none.
+func decodeFrame(link int, frame []byte) *CapturedPacket {
+	switch link {
+	case linkTypeRaw, linkTypeRawAlt, linkTypeIPv4, linkTypeIPv6:
+		return decodeIP(frame)
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+		etherType, off := binary.BigEndian.Uint16(frame[12:]), 14
+		if etherType == 0x8100 && len(frame) >= 18 { // 802.1Q tag
+			etherType, off = binary.BigEndian.Uint16(frame[16:]), 18
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil
+		}
+		return decodeIP(frame[off:])
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		return decodeIP(frame[16:])
+	}
+	return nil
+}
+
+// decodeIP extracts the ICMP or ICMPv6 message of an IP datagram.
+func decodeIP(pkt []byte) *CapturedPacket {
+	if len(pkt) < 1 {
+		return nil
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		off, ttl := ipHeaderLen(pkt)
+		if off < 20 || len(pkt) < off || pkt[9] != protoICMP {
+			return nil
+		}
+		// Only the first fragment carries the ICMP header, and only
+		// unfragmented datagrams the whole message.
+		if binary.BigEndian.Uint16(pkt[6:])&0x3fff != 0 {
+			return nil
+		}
+		end := min(int(binary.BigEndian.Uint16(pkt[2:])), len(pkt))
+		if end < off {
+			return nil
+		}
+		return &CapturedPacket{
+			Src:  net.IP(append([]byte(nil), pkt[12:16]...)),
+			Dst:  net.IP(append([]byte(nil), pkt[16:20]...)),
+			TTL:  ttl,
+			Data: pkt[off:end],
+		}
+	case 6:
+		if len(pkt) < 40 {
+			return nil
+		}
+		next, off := pkt[6], 40
+		// Skip hop-by-hop, routing and destination options headers.
+		for next == 0 || next == 43 || next == 60 {
+			if len(pkt) < off+8 {
+				return nil
+			}
+			next, off = pkt[off], off+8+int(pkt[off+1])*8
+		}
+		end := min(40+int(binary.BigEndian.Uint16(pkt[4:])), len(pkt))
+		if next != protoICMPv6 || end < off {
+			return nil
+		}
+		return &CapturedPacket{
+			Src:  net.IP(append([]byte(nil), pkt[8:24]...)),
+			Dst:  net.IP(append([]byte(nil), pkt[24:40]...)),
+			TTL:  int(pkt[7]),
+			Data: pkt[off:end],
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// readCapture returns every packet of a capture.
+func readCapture(t *testing.T, data []byte) []*CapturedPacket {
+	t.Helper()
+	r, err := NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkts []*CapturedPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return pkts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts = append(pkts, p)
+	}
+}
+
+func TestRecorder_FakeRoundTrip(t *testing.T) {
+	for _, format := range []CaptureFormat{FormatPcap, FormatPcapng} {
+		var buf bytes.Buffer
+		rec, err := NewRecorder(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newFakeConn(FamilyIPv4, replyAfter(0))
+		src := net.ParseIP("198.51.100.7")
+		start := time.Now()
+		r := PingContext(context.Background(), "192.0.2.1", &Options{Listen: conn.listen, Source: src, TTL: 9, Recorder: rec})
+		if !r.Success {
+			t.Fatalf("%v: ping failed: %v", format, r.Err)
+		}
+		if err := rec.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		pkts := readCapture(t, buf.Bytes())
+		if len(pkts) != 2 {
+			t.Fatalf("%v: expected request and reply, got %d packets", format, len(pkts))
+		}
+		req, reply := pkts[0], pkts[1]
+		if !req.Src.Equal(src) || !req.Dst.Equal(net.ParseIP("192.0.2.1")) || req.TTL != 9 {
+			t.Errorf("%v: unexpected request %v -> %v ttl %d", format, req.Src, req.Dst, req.TTL)
+		}
+		if !reply.Src.Equal(net.ParseIP("192.0.2.1")) || !reply.Dst.Equal(src) {
+			t.Errorf("%v: unexpected reply %v -> %v", format, reply.Src, reply.Dst)
+		}
+		if req.At.Before(start.Add(-time.Millisecond)) || reply.At.Before(req.At) {
+			t.Errorf("%v: unexpected timestamps %v, %v", format, req.At, reply.At)
+		}
+		typ, _, id, seq, _, err := req.Parse()
+		rtyp, _, rid, rseq, _, rerr := reply.Parse()
+		if err != nil || rerr != nil || typ != TypeEchoRequest || rtyp != TypeEchoReply || id != rid || seq != rseq {
+			t.Errorf("%v: request and reply do not match: %d/%d/%d, %d/%d/%d", format, typ, id, seq, rtyp, rid, rseq)
+		}
+		if !ValidateChecksum(req.Data) {
+			t.Errorf("%v: request recorded with a bad checksum", format)
+		}
+	}
+}
+
+func TestRecorder_FakeTracerouteTTL(t *testing.T) {
+	var buf bytes.Buffer
+	rec, _ := NewRecorder(&buf, FormatPcapng)
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, ttl int) []fakePacket {
+		if ttl < 2 {
+			return []fakePacket{errorFrom(TypeTimeExceeded, 0, req, dst, net.IPv4(10, 0, 0, 1))}
+		}
+		return []fakePacket{echoFrom(FamilyIPv4, req, dst, 0)}
+	})
+	opts := &TraceOptions{Options: Options{Timeout: 20 * time.Millisecond, Listen: conn.listen, Recorder: rec}, Probes: 1}
+	if _, err := Traceroute("192.0.2.1", opts); err != nil {
+		t.Fatal(err)
+	}
+	var ttls []int
+	for _, p := range readCapture(t, buf.Bytes()) {
+		if p.Dst.Equal(net.ParseIP("192.0.2.1")) {
+			ttls = append(ttls, p.TTL)
+		}
+	}
+	if len(ttls) != 2 || ttls[0] != 1 || ttls[1] != 2 {
+		t.Errorf("expected probes recorded with TTL 1 and 2, got %v", ttls)
+	}
+}
+
+func TestCaptureReader_Ethernet(t *testing.T) {
+	// A big-endian, microsecond pcap of one Ethernet frame, as written by
+	// tcpdump on some platforms.
+	msg := marshalEchoRequest(7, 3, []byte("hello"))
+	ip := ipPacket(net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), 64, 0, msg)
+	frame := append(make([]byte, 12), 0x08, 0x00)
+	frame = append(frame, ip...)
+
+	var buf bytes.Buffer
+	be := binary.BigEndian
+	hdr := make([]byte, 24)
+	be.PutUint32(hdr[0:], pcapMagicMicro)
+	be.PutUint16(hdr[4:], 2)
+	be.PutUint16(hdr[6:], 4)
+	be.PutUint32(hdr[16:], captureSnapLen)
+	be.PutUint32(hdr[20:], linkTypeEthernet)
+	buf.Write(hdr)
+	rec := make([]byte, 16)
+	be.PutUint32(rec[0:], 1000)
+	be.PutUint32(rec[4:], 250)
+	be.PutUint32(rec[8:], uint32(len(frame)))
+	be.PutUint32(rec[12:], uint32(len(frame)))
+	buf.Write(rec)
+	buf.Write(frame)
+
+	pkts := readCapture(t, buf.Bytes())
+	if len(pkts) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(pkts))
+	}
+	p := pkts[0]
+	if !p.At.Equal(time.Unix(1000, 250000)) || p.Family() != FamilyIPv4 || p.TTL != 64 {
+		t.Errorf("unexpected packet %+v", p)
+	}
+	if _, _, id, seq, payload, err := p.Parse(); err != nil || id != 7 || seq != 3 || string(payload) != "hello" {
+		t.Errorf("unexpected message id %d seq %d payload %q: %v", id, seq, payload, err)
+	}
+}
+
+func TestCaptureReader_IPv6(t *testing.T) {
+	var buf bytes.Buffer
+	rec, _ := NewRecorder(&buf, FormatPcap)
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	rec.record(time.Unix(5, 0), src, dst, 17, 0, marshalEchoRequestV6(1, 2, []byte("x"), src, dst))
+
+	pkts := readCapture(t, buf.Bytes())
+	if len(pkts) != 1 || pkts[0].Family() != FamilyIPv6 || pkts[0].TTL != 17 || !pkts[0].Dst.Equal(dst) {
+		t.Fatalf("unexpected packets %+v", pkts)
+	}
+	if typ, _, _, seq, _, _ := pkts[0].Parse(); typ != TypeEchoRequestV6 || seq != 2 {
+		t.Errorf("unexpected message type %d seq %d", typ, seq)
+	}
+}
+
+func TestCaptureReader_Unsupported(t *testing.T) {
+	_, err := NewCaptureReader(bytes.NewReader(make([]byte, 24)))
+	if !errors.Is(err, ErrUnsupportedCapture) {
+		t.Errorf("expected ErrUnsupportedCapture, got %v", err)
+	}
+}
+
+func TestCaptureReader_Malformed(t *testing.T) {
+	capture := func(format CaptureFormat) []byte {
+		var buf bytes.Buffer
+		rec, _ := NewRecorder(&buf, format)
+		src, dst := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+		rec.record(time.Unix(5, 0), src, dst, 64, 0, marshalEchoRequest(1, 2, []byte("x")))
+		return buf.Bytes()
+	}
+	next := func(data []byte) error {
+		r, err := NewCaptureReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		_, err = r.Next()
+		return err
+	}
+	le := binary.LittleEndian
+
+	pcap := capture(FormatPcap)
+	if err := next(pcap[:len(pcap)-1]); err != io.ErrUnexpectedEOF {
+		t.Errorf("pcap: truncated record: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	le.PutUint32(pcap[24+8:], 0xFFFFFFF0)
+	if err := next(pcap); !errors.Is(err, ErrUnsupportedCapture) {
+		t.Errorf("pcap: oversized record: expected ErrUnsupportedCapture, got %v", err)
+	}
+
+	ng := capture(FormatPcapng)
+	if err := next(ng[:len(ng)-1]); err != io.ErrUnexpectedEOF {
+		t.Errorf("pcapng: truncated block: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	shb := le.Uint32(ng[4:])
+	epb := shb + le.Uint32(ng[shb+4:])
+	le.PutUint32(ng[epb+4:], 0xFFFFFFF0)
+	if err := next(ng); !errors.Is(err, ErrUnsupportedCapture) {
+		t.Errorf("pcapng: oversized block: expected ErrUnsupportedCapture, got %v", err)
+	}
+}
+
+func TestCaptureReader_TSResolution(t *testing.T) {
+	for _, v := range []byte{0xC0, 0xFF, 20, 0x7F} {
+		var buf bytes.Buffer
+		rec, _ := NewRecorder(&buf, FormatPcapng)
+		rec.record(time.Unix(5, 0), net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), 64, 0, marshalEchoRequest(1, 2, nil))
+		data := buf.Bytes()
+		data[28+20] = v // if_tsresol of the interface
+		r, err := NewCaptureReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Next(); !errors.Is(err, ErrUnsupportedCapture) {
+			t.Errorf("if_tsresol %#x: expected ErrUnsupportedCapture, got %v", v, err)
+		}
+	}
+
+	// The finest resolutions accepted must not overflow converting the
+	// fraction of a second.
+	for _, v := range []byte{19, 0x80 | 63} {
+		_, per, ok := tsResolution(v)
+		if !ok {
+			t.Fatalf("if_tsresol %#x rejected", v)
+		}
+		ifc := captureIfc{per: per}
+		if got, want := ifc.time(per+per/2), time.Unix(1, 5e8); !got.Equal(want) {
+			t.Errorf("if_tsresol %#x: expected %v, got %v", v, want, got)
+		}
+	}
+}
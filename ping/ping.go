@@ -5,19 +5,25 @@ package ping
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
-	"os"
 	"time"
 )
 
 // ICMP type constants
 const (
-	TypeEchoReply   = 0
-	TypeEchoRequest = 8
+	TypeEchoReply              = 0
+	TypeDestinationUnreachable = 3
+	TypeEchoRequest            = 8
+	TypeTimeExceeded           = 11
+	TypeParameterProblem       = 12
 )
 
+// protoICMP is the IPv4 protocol number for ICMP.
+const protoICMP = 1
+
 // ICMP header (8 bytes)
 type icmpHeader struct {
 	Type     uint8
@@ -30,118 +36,150 @@ type icmpHeader struct {
 // Result holds the outcome of a single ICMP echo request.
 type Result struct {
 	IP      string
+	Seq     int // ICMP sequence number of the probe
 	Success bool
 	RTT     time.Duration
 	Size    int // payload bytes received
 	TTL     int
+	Method  Method // how the target was probed
+	Clock   Clock  // where the receive time behind RTT was taken
 	Err     error
+
+	// Kind classifies the reply, and Duplicates counts further replies to
+	// the same probe. Only Pinger sessions track replies after the first.
+	Kind       ReplyKind
+	Duplicates int
 }
 
 // Ping sends one ICMP echo request to the target ip address and waits up
-// to timeout for a reply. Privileged (root / CAP_NET_RAW) access is required
-// to open the raw ICMP socket.
+// to timeout for a reply. It is PingContext with default options.
 func Ping(ip string, timeout time.Duration) Result {
-	start := time.Now()
+	return PingContext(context.Background(), ip, &Options{Timeout: timeout})
+}
 
-	raddr, err := net.ResolveIPAddr("ip", ip)
+// PingContext sends one ICMP echo request to target and waits up to
+// opts.Timeout for a reply; a nil opts uses the defaults. The address family
+// follows the resolved address unless forced: IPv6 targets are probed with
+// ICMPv6. A raw socket is used when the process has root / CAP_NET_RAW,
+// otherwise an unprivileged datagram socket. ICMP errors answering the probe
+// are reported as *UnreachableError, *TimeExceededError or
+// *PacketTooBigError; datagram sockets do not receive them.
+//
+// When the probe times out, the methods of opts.Fallback are tried in turn
+// and the first successful result is returned. Cancelling ctx aborts name
+// resolution and the wait for the reply, and the context's error is
+// reported in Result.Err.
+func PingContext(ctx context.Context, target string, opts *Options) Result {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return fallback(ctx, target, pingEcho(ctx, target, opts), opts)
+}
+
+// pingEcho sends one ICMP echo request as described for PingContext.
+func pingEcho(ctx context.Context, target string, opts *Options) Result {
+	raddr, fam, err := opts.resolve(ctx, target)
 	if err != nil {
-		return Result{IP: ip, Err: fmt.Errorf("resolve: %w", err)}
+		return Result{IP: target, Err: err}
 	}
 
-	conn, err := net.DialIP("ip4:icmp", nil, raddr)
+	conn, err := opts.listen(fam)
 	if err != nil {
-		return Result{IP: ip, Err: fmt.Errorf("socket: %w", err)}
+		return Result{IP: target, Err: fmt.Errorf("socket: %w", err)}
 	}
 	defer conn.Close()
+	defer watch(ctx, conn)()
 
-	_ = conn.SetDeadline(time.Now().Add(timeout))
-
-	id := uint16(os.Getpid() & 0xFFFF)
+	id := conn.EchoID(nextID())
 	seq := uint16(1)
 
-	pkt := buildEchoRequest(id, seq, start)
-
-	if _, err := conn.Write(pkt); err != nil {
-		return Result{IP: ip, Err: fmt.Errorf("send: %w", err)}
+	var src net.IP
+	if fam == FamilyIPv6 {
+		src = opts.source(raddr.IP)
+	}
+	// The RTT is measured from the send time in the payload, so it does
+	// not include name resolution or opening the socket.
+	sent := time.Now()
+	payload := opts.payload(sent)
+	pkt := buildEcho(fam, id, seq, payload, src, raddr.IP)
+
+	if _, err := conn.WriteTo(pkt, raddr); err != nil {
+		return Result{IP: target, Err: fmt.Errorf("send: %w", err)}
 	}
+	setReadDeadlineContext(ctx, conn, sent.Add(opts.timeout()))
 
 	// Read in a loop to consume responses that don't match (e.g. from other
-	// processes). Time out after approximately timeout.
-	var respRslt Result
+	// processes or hosts). Time out after approximately timeout.
+	buf := make([]byte, maxPacketSize)
 	for {
-		buf := make([]byte, 1500)
-		n, err := conn.Read(buf)
+		msg, info, err := conn.ReadFrom(buf)
 		if err != nil {
-			return Result{IP: ip, Err: fmt.Errorf("recv: %w", err)}
+			return Result{IP: target, Err: fmt.Errorf("recv: %w", contextError(ctx, err))}
 		}
+		at, clock := info.received()
 
-		// net.DialIP on darwin includes the IP header in the read buffer,
-		// while on Linux it is stripped. Detect and skip accordingly.
-		off := 0
-		if len(buf) > 0 && (buf[0]>>4)&0x0F == 4 {
-			off = int(buf[0]&0x0F) * 4 // IHL in 32-bit words
-		} else if len(buf) > 0 && (buf[0]>>4)&0x0F == 6 {
-			off = 40 // IPv6 fixed header
-		}
-		if n < off+8 {
-			continue
-		}
-		icmpStart := off
-
-		var hdr icmpHeader
-		if err := binary.Read(bytes.NewReader(buf[icmpStart:]), binary.BigEndian, &hdr); err != nil {
+		// We only care about responses to our echo request: the reply
+		// itself, or an ICMP error quoting it.
+		r, ok := parseEchoResponse(fam, id, msg, info.From)
+		if !ok || r.seq != seq || !r.from.Equal(raddr.IP) {
 			continue
 		}
-
-		// We only care about Echo Reply destined for our ID.
-		if hdr.Type != TypeEchoReply || hdr.ID != id {
-			continue
+		if r.err != nil {
+			return Result{IP: target, Seq: int(seq), Kind: ReplyNormal, Err: r.err}
 		}
 
-		// Extract TTL from IP header if available.
-		ttl := 0
-		if off >= 9 {
-			ttl = int(buf[8])
+		r.at = at
+		err = r.verify(payload)
+		return Result{
+			IP:      target,
+			Seq:     int(seq),
+			Success: err == nil,
+			RTT:     r.rtt(payload, sent),
+			Size:    r.size,
+			TTL:     info.TTL,
+			Clock:   clock,
+			Err:     err,
+			Kind:    ReplyNormal,
 		}
+	}
+}
 
-		respRslt = Result{
-			IP:      ip,
-			Success: true,
-			RTT:     time.Since(start),
-			Size:    n - icmpStart,
-			TTL:     ttl,
-		}
-		break
+// ipHeaderLen reports how many bytes of IPv4 header precede the ICMP
+// message in buf, together with the TTL carried by that header (0 when
+// absent). Depending on the platform and the read call used, IPv4 raw
+// sockets may or may not deliver the header, so it is detected from the
+// version nibble.
+func ipHeaderLen(buf []byte) (off int, ttl int) {
+	if len(buf) < 20 || buf[0]>>4 != 4 {
+		return 0, 0
 	}
-	return respRslt
+	return int(buf[0]&0x0F) * 4, int(buf[8]) // IHL in 32-bit words
 }
 
 // buildEchoRequest creates a serialised ICMP echo request with a timestamp
 // payload and computes the correct checksum.
 func buildEchoRequest(id, seq uint16, ts time.Time) []byte {
-	hdr := icmpHeader{
-		Type: TypeEchoRequest,
-		Code: 0,
-		ID:   id,
-		Seq:  seq,
-	}
+	return marshalEchoRequest(id, seq, timestampPayload(ts, 8))
+}
 
-	var buf bytes.Buffer
-	_ = binary.Write(&buf, binary.BigEndian, hdr)
+// marshalEchoRequest serialises an ICMP echo request carrying payload and
+// computes the correct checksum.
+func marshalEchoRequest(id, seq uint16, payload []byte) []byte {
+	m := Message{Type: TypeEchoRequest, Body: &Echo{ID: id, Seq: seq, Data: payload}}
+	pkt, _ := m.Marshal(nil, nil) // echo bodies always marshal
+	return pkt
+}
 
-	// Timestamp payload used to reconstruct RTT on the receiving side.
-	payload := make([]byte, 8)
+// timestampPayload returns a zero-padded payload of size bytes (at least 8)
+// that starts with the timestamp used to reconstruct RTT on the receiving
+// side.
+func timestampPayload(ts time.Time, size int) []byte {
+	if size < 8 {
+		size = 8
+	}
+	payload := make([]byte, size)
 	binary.BigEndian.PutUint64(payload, uint64(ts.UnixNano()))
-	buf.Write(payload)
-
-	pkt := buf.Bytes()
-
-	// Compute RFC 1071 checksum over the ICMP segment.
-	csum := ipChecksum(pkt)
-	pkt[2] = byte(csum >> 8)
-	pkt[3] = byte(csum & 0xFF)
-
-	return pkt
+	return payload
 }
 
 // ipChecksum computes the 16-bit one's complement checksum (RFC 1071).
@@ -185,3 +223,33 @@ func ValidateChecksum(data []byte) bool {
 	data[2], data[3] = byte(saved>>8), byte(saved&0xFF)
 	return got == saved
 }
+
+// parseQuotedEcho extracts the echo request quoted in the body of an ICMP
+// error message (the bytes following its 8-byte header): the destination of
+// the original datagram together with the echo ID and sequence number. ok is
+// false when the body does not quote an echo request of the given family.
+func parseQuotedEcho(fam Family, body []byte) (dst net.IP, id, seq uint16, ok bool) {
+	var off int
+	switch fam {
+	case FamilyIPv4:
+		if len(body) < 20 || body[0]>>4 != 4 || body[9] != protoICMP {
+			return nil, 0, 0, false
+		}
+		dst = append(net.IP(nil), body[16:20]...)
+		off = int(body[0]&0x0F) * 4
+	case FamilyIPv6:
+		if len(body) < 40 || body[0]>>4 != 6 || body[6] != protoICMPv6 {
+			return nil, 0, 0, false
+		}
+		dst = append(net.IP(nil), body[24:40]...)
+		off = 40
+	default:
+		return nil, 0, 0, false
+	}
+	if len(body) < off+8 || body[off] != fam.echoRequest() {
+		return nil, 0, 0, false
+	}
+	id = binary.BigEndian.Uint16(body[off+4 : off+6])
+	seq = binary.BigEndian.Uint16(body[off+6 : off+8])
+	return dst, id, seq, true
+}
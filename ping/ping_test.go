@@ -2,8 +2,13 @@ package ping
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
@@ -253,3 +258,676 @@ func ExamplePing() {
 	_ = r
 	// Output:
 }
+
+// ---------------------------------------------------------------------------
+// Pinger / Statistics
+// ---------------------------------------------------------------------------
+
+func TestStatisticsSummarize(t *testing.T) {
+	s := &Statistics{
+		Sent: 4,
+		Results: []Result{
+			{Seq: 1, Success: true, RTT: 10 * time.Millisecond},
+			{Seq: 2, Success: true, RTT: 20 * time.Millisecond},
+			{Seq: 3},
+			{Seq: 4, Success: true, RTT: 30 * time.Millisecond},
+		},
+	}
+	s.summarize()
+
+	if s.Received != 3 {
+		t.Errorf("expected 3 received, got %d", s.Received)
+	}
+	if s.PacketLoss != 25 {
+		t.Errorf("expected 25%% loss, got %v", s.PacketLoss)
+	}
+	if s.MinRTT != 10*time.Millisecond || s.MaxRTT != 30*time.Millisecond {
+		t.Errorf("unexpected min/max: %v/%v", s.MinRTT, s.MaxRTT)
+	}
+	if s.AvgRTT != 20*time.Millisecond {
+		t.Errorf("expected avg 20ms, got %v", s.AvgRTT)
+	}
+	// sqrt((100+400+900)/3 - 400) ms = sqrt(66.67) ms ≈ 8.165ms
+	if d := s.MdevRTT - 8165*time.Microsecond; d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("expected mdev ≈ 8.165ms, got %v", s.MdevRTT)
+	}
+}
+
+func TestStatisticsSummarize_AllLost(t *testing.T) {
+	s := &Statistics{Sent: 2, Results: []Result{{Seq: 1}, {Seq: 2}}}
+	s.summarize()
+	if s.Received != 0 || s.PacketLoss != 100 {
+		t.Errorf("expected 0 received and 100%% loss, got %d / %v", s.Received, s.PacketLoss)
+	}
+	if s.AvgRTT != 0 || s.MdevRTT != 0 {
+		t.Errorf("expected zero RTT figures, got avg=%v mdev=%v", s.AvgRTT, s.MdevRTT)
+	}
+}
+
+func TestNextID_Unique(t *testing.T) {
+	if nextID() == nextID() {
+		t.Error("consecutive session IDs should differ")
+	}
+}
+
+func TestPinger_RejectsBadInterval(t *testing.T) {
+	p := NewPinger("127.0.0.1")
+	p.Interval = 0
+	if _, err := p.Run(); err == nil {
+		t.Error("expected error for zero interval")
+	}
+}
+
+// TestPinger_Loopback runs a short session against 127.0.0.1. It is skipped
+// when the raw socket cannot be opened (no root / CAP_NET_RAW).
+func TestPinger_Loopback(t *testing.T) {
+	p := NewPinger("127.0.0.1")
+	p.Count = 3
+	p.Interval = 10 * time.Millisecond
+	stats, err := p.Run()
+	if err != nil {
+		t.Skipf("cannot ping loopback: %v", err)
+	}
+	if stats.Sent != 3 || len(stats.Results) != 3 {
+		t.Fatalf("expected 3 probes, got sent=%d results=%d", stats.Sent, len(stats.Results))
+	}
+	for i, r := range stats.Results {
+		if r.Seq != i+1 {
+			t.Errorf("result %d: expected seq %d, got %d", i, i+1, r.Seq)
+		}
+	}
+	if stats.Received != 3 {
+		t.Errorf("expected 3 replies from loopback, got %d", stats.Received)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// ICMPv6
+// ---------------------------------------------------------------------------
+
+func TestBuildEchoRequestV6_TypeAndChecksum(t *testing.T) {
+	src := net.ParseIP("fe80::1")
+	dst := net.ParseIP("fe80::2")
+	pkt := buildEchoRequestV6(7, 9, time.Now(), src, dst)
+	if pkt[0] != TypeEchoRequestV6 || pkt[1] != 0 {
+		t.Fatalf("expected type %d code 0, got %d/%d", TypeEchoRequestV6, pkt[0], pkt[1])
+	}
+	if !ValidateChecksumV6(src, dst, pkt) {
+		t.Error("pseudo-header checksum validation failed")
+	}
+	if ValidateChecksumV6(src, net.ParseIP("fe80::3"), pkt) {
+		t.Error("checksum should depend on the destination address")
+	}
+}
+
+func TestBuildEchoRequestV6_NoSourceLeavesChecksumToKernel(t *testing.T) {
+	pkt := buildEchoRequestV6(7, 9, time.Now(), nil, net.ParseIP("::1"))
+	if pkt[2] != 0 || pkt[3] != 0 {
+		t.Errorf("expected zero checksum, got 0x%02X%02X", pkt[2], pkt[3])
+	}
+}
+
+func TestICMPv6Checksum_KnownValue(t *testing.T) {
+	// Echo request ::1 -> ::1, id=0, seq=0, no payload. The pseudo-header
+	// contributes 2*0x0001 + 0x0008 + 0x003A, the message 0x8000.
+	msg := []byte{TypeEchoRequestV6, 0, 0, 0, 0, 0, 0, 0}
+	got := icmpv6Checksum(net.IPv6loopback, net.IPv6loopback, msg)
+	want := ^uint16(0x8000 + 2 + 8 + 58)
+	if got != want {
+		t.Errorf("expected 0x%04X, got 0x%04X", want, got)
+	}
+}
+
+func TestOptionsResolve_Family(t *testing.T) {
+	cases := []struct {
+		opts   Options
+		target string
+		want   Family
+		fails  bool
+	}{
+		{Options{}, "127.0.0.1", FamilyIPv4, false},
+		{Options{}, "::1", FamilyIPv6, false},
+		{Options{Family: FamilyIPv4}, "::1", 0, true},
+		{Options{Family: FamilyIPv6}, "127.0.0.1", 0, true},
+	}
+	for _, c := range cases {
+		_, fam, err := c.opts.resolve(context.Background(), c.target)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s with %v: expected error", c.target, c.opts.Family)
+			}
+			continue
+		}
+		if err != nil || fam != c.want {
+			t.Errorf("%s with %v: got %v, %v", c.target, c.opts.Family, fam, err)
+		}
+	}
+}
+
+func TestPinger_LoopbackV6(t *testing.T) {
+	p := NewPinger("::1")
+	p.Count = 2
+	p.Interval = 10 * time.Millisecond
+	stats, err := p.Run()
+	if err != nil {
+		t.Skipf("cannot ping IPv6 loopback: %v", err)
+	}
+	if stats.Received != 2 {
+		t.Errorf("expected 2 replies from ::1, got %d", stats.Received)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Socket types
+// ---------------------------------------------------------------------------
+
+func TestPacketConnEchoID(t *testing.T) {
+	raw := &packetConn{typ: SocketRaw, id: 99}
+	if got := raw.EchoID(1234); got != 1234 {
+		t.Errorf("raw socket should keep requested ID, got %d", got)
+	}
+	dgram := &packetConn{typ: SocketDatagram, id: 99}
+	if got := dgram.EchoID(1234); got != 99 {
+		t.Errorf("datagram socket should use kernel ID 99, got %d", got)
+	}
+}
+
+// TestPinger_LoopbackDatagram is skipped unless the caller's group is
+// allowed by net.ipv4.ping_group_range.
+func TestPinger_LoopbackDatagram(t *testing.T) {
+	p := NewPinger("127.0.0.1")
+	p.Count = 2
+	p.Interval = 10 * time.Millisecond
+	p.Socket = SocketDatagram
+	stats, err := p.Run()
+	if err != nil {
+		t.Skipf("cannot open datagram ICMP socket: %v", err)
+	}
+	if stats.Received != 2 {
+		t.Errorf("expected 2 replies from loopback, got %d", stats.Received)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Quoted datagrams in ICMP errors
+// ---------------------------------------------------------------------------
+
+// timeExceededV4 builds the body of an ICMP time exceeded message quoting
+// an echo request from 10.0.0.1 to dst.
+func timeExceededV4(dst net.IP, id, seq uint16) []byte {
+	ip := []byte{0x45, 0, 0, 28, 0, 0, 0, 0, 1, protoICMP, 0, 0, 10, 0, 0, 1}
+	ip = append(ip, dst.To4()...)
+	return append(ip, buildEchoRequest(id, seq, time.Now())[:8]...)
+}
+
+func TestParseQuotedEcho_V4(t *testing.T) {
+	dst := net.ParseIP("192.0.2.7")
+	got, id, seq, ok := parseQuotedEcho(FamilyIPv4, timeExceededV4(dst, 0x1234, 42))
+	if !ok {
+		t.Fatal("expected quoted echo request to be recognised")
+	}
+	if !got.Equal(dst) || id != 0x1234 || seq != 42 {
+		t.Errorf("got dst=%s id=0x%04X seq=%d", got, id, seq)
+	}
+}
+
+func TestParseQuotedEcho_V6(t *testing.T) {
+	dst := net.ParseIP("2001:db8::7")
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	ip[6] = protoICMPv6
+	copy(ip[8:24], net.ParseIP("2001:db8::1"))
+	copy(ip[24:40], dst)
+	body := append(ip, buildEchoRequestV6(7, 8, time.Now(), nil, dst)[:8]...)
+
+	got, id, seq, ok := parseQuotedEcho(FamilyIPv6, body)
+	if !ok || !got.Equal(dst) || id != 7 || seq != 8 {
+		t.Errorf("got dst=%s id=%d seq=%d ok=%v", got, id, seq, ok)
+	}
+}
+
+func TestParseQuotedEcho_Rejects(t *testing.T) {
+	body := timeExceededV4(net.ParseIP("192.0.2.7"), 1, 1)
+	if _, _, _, ok := parseQuotedEcho(FamilyIPv6, body); ok {
+		t.Error("IPv4 quote accepted as IPv6")
+	}
+	if _, _, _, ok := parseQuotedEcho(FamilyIPv4, body[:27]); ok {
+		t.Error("truncated quote accepted")
+	}
+	body[9] = 17 // UDP
+	if _, _, _, ok := parseQuotedEcho(FamilyIPv4, body); ok {
+		t.Error("non-ICMP quote accepted")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Traceroute
+// ---------------------------------------------------------------------------
+
+func TestTraceroute_RejectsDatagramSocket(t *testing.T) {
+	opts := &TraceOptions{Options: Options{Socket: SocketDatagram}}
+	if _, err := Traceroute("127.0.0.1", opts); err == nil {
+		t.Error("expected error for datagram socket")
+	}
+}
+
+func TestTraceroute_Loopback(t *testing.T) {
+	tr, err := Traceroute("127.0.0.1", &TraceOptions{MaxHops: 3, Probes: 2})
+	if err != nil {
+		t.Skipf("cannot trace loopback: %v", err)
+	}
+	if !tr.Reached || len(tr.Hops) != 1 {
+		t.Fatalf("expected loopback reached at hop 1, got %+v", tr)
+	}
+	for _, p := range tr.Hops[0].Probes {
+		if p.Err != nil || p.From != "127.0.0.1" {
+			t.Errorf("unexpected probe outcome %+v", p)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Typed ICMP errors
+// ---------------------------------------------------------------------------
+
+// icmpError wraps body in an ICMP error message of the given type and code.
+func icmpError(typ, code uint8, body []byte) []byte {
+	return append([]byte{typ, code, 0, 0, 0, 0, 0, 0}, body...)
+}
+
+func TestParseEchoResponse_EchoReply(t *testing.T) {
+	pkt := buildEchoRequest(5, 6, time.Now())
+	pkt[0] = TypeEchoReply
+	from := net.ParseIP("192.0.2.7")
+	r, ok := parseEchoResponse(FamilyIPv4, 5, pkt, from)
+	if !ok || r.err != nil || r.seq != 6 || !r.from.Equal(from) || r.size != len(pkt) {
+		t.Errorf("unexpected reply %+v ok=%v", r, ok)
+	}
+	if _, ok := parseEchoResponse(FamilyIPv4, 9, pkt, from); ok {
+		t.Error("reply with foreign ID accepted")
+	}
+}
+
+func TestParseEchoResponse_Unreachable(t *testing.T) {
+	dst := net.ParseIP("192.0.2.7")
+	router := net.ParseIP("198.51.100.1")
+	msg := icmpError(TypeDestinationUnreachable, 13, timeExceededV4(dst, 5, 6))
+
+	r, ok := parseEchoResponse(FamilyIPv4, 5, msg, router)
+	if !ok || r.seq != 6 || !r.from.Equal(dst) {
+		t.Fatalf("unexpected reply %+v ok=%v", r, ok)
+	}
+	var ue *UnreachableError
+	if !errors.As(r.err, &ue) {
+		t.Fatalf("expected *UnreachableError, got %T", r.err)
+	}
+	if !ue.From.Equal(router) || ue.Code != 13 || !ue.Prohibited() {
+		t.Errorf("unexpected error %+v", ue)
+	}
+	if want := "destination unreachable from 198.51.100.1: communication administratively prohibited"; ue.Error() != want {
+		t.Errorf("expected %q, got %q", want, ue.Error())
+	}
+}
+
+func TestParseEchoResponse_TimeExceeded(t *testing.T) {
+	dst := net.ParseIP("192.0.2.7")
+	msg := icmpError(TypeTimeExceeded, 0, timeExceededV4(dst, 5, 6))
+	r, ok := parseEchoResponse(FamilyIPv4, 5, msg, net.ParseIP("198.51.100.1"))
+	var te *TimeExceededError
+	if !ok || !errors.As(r.err, &te) {
+		t.Fatalf("expected *TimeExceededError, got %v ok=%v", r.err, ok)
+	}
+	if _, ok := parseEchoResponse(FamilyIPv4, 9, msg, nil); ok {
+		t.Error("error quoting a foreign ID accepted")
+	}
+}
+
+func TestUnreachableError_Reasons(t *testing.T) {
+	cases := []struct {
+		err        UnreachableError
+		reason     string
+		prohibited bool
+	}{
+		{UnreachableError{Family: FamilyIPv4, Code: 1}, "host unreachable", false},
+		{UnreachableError{Family: FamilyIPv4, Code: 10}, "host administratively prohibited", true},
+		{UnreachableError{Family: FamilyIPv6, Code: 3}, "address unreachable", false},
+		{UnreachableError{Family: FamilyIPv6, Code: 1}, "communication administratively prohibited", true},
+		{UnreachableError{Family: FamilyIPv6, Code: 42}, "code 42", false},
+	}
+	for _, c := range cases {
+		if got := c.err.Reason(); got != c.reason {
+			t.Errorf("%v code %d: expected %q, got %q", c.err.Family, c.err.Code, c.reason, got)
+		}
+		if got := c.err.Prohibited(); got != c.prohibited {
+			t.Errorf("%v code %d: expected prohibited=%v", c.err.Family, c.err.Code, c.prohibited)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Path MTU discovery
+// ---------------------------------------------------------------------------
+
+func TestParseEchoResponse_PacketTooBig(t *testing.T) {
+	dst := net.ParseIP("192.0.2.7")
+	msg := icmpError(TypeDestinationUnreachable, codeFragmentationNeeded, timeExceededV4(dst, 5, 6))
+	msg[6], msg[7] = 0x05, 0xDC // next-hop MTU 1500
+	r, ok := parseEchoResponse(FamilyIPv4, 5, msg, net.ParseIP("198.51.100.1"))
+	var tb *PacketTooBigError
+	if !ok || !errors.As(r.err, &tb) {
+		t.Fatalf("expected *PacketTooBigError, got %v ok=%v", r.err, ok)
+	}
+	if tb.MTU != 1500 {
+		t.Errorf("expected MTU 1500, got %d", tb.MTU)
+	}
+}
+
+func TestTimestampPayload_Size(t *testing.T) {
+	now := time.Now()
+	p := timestampPayload(now, 100)
+	if len(p) != 100 {
+		t.Fatalf("expected 100 bytes, got %d", len(p))
+	}
+	if got := int64(binary.BigEndian.Uint64(p)); got != now.UnixNano() {
+		t.Errorf("timestamp not at start of payload")
+	}
+	if len(timestampPayload(now, 2)) != 8 {
+		t.Error("payload must hold at least the timestamp")
+	}
+}
+
+func TestDiscoverMTU_RejectsBadBounds(t *testing.T) {
+	if _, err := DiscoverMTU("127.0.0.1", &MTUOptions{MinMTU: 1500, MaxMTU: 1400}); err == nil {
+		t.Error("expected error for inverted bounds")
+	}
+}
+
+// TestDiscoverMTU_Loopback relies on the loopback MTU (64k) exceeding the
+// search range, so the upper bound is found.
+func TestDiscoverMTU_Loopback(t *testing.T) {
+	pm, err := DiscoverMTU("127.0.0.1", &MTUOptions{MaxMTU: 2000})
+	if err != nil {
+		t.Skipf("cannot probe loopback: %v", err)
+	}
+	if pm.MTU != 2000 {
+		t.Errorf("expected MTU 2000, got %d after %d probes", pm.MTU, pm.Probes)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Payload options and verification
+// ---------------------------------------------------------------------------
+
+func TestOptionsPayload_SizeAndPattern(t *testing.T) {
+	o := &Options{Size: 20, Pattern: []byte{0xAB, 0xCD}}
+	now := time.Now()
+	p := o.payload(now)
+	if len(p) != 20 {
+		t.Fatalf("expected 20 bytes, got %d", len(p))
+	}
+	if int64(binary.BigEndian.Uint64(p)) != now.UnixNano() {
+		t.Error("payload should start with the send timestamp")
+	}
+	if !bytes.Equal(p[16:], []byte{0xAB, 0xCD, 0xAB, 0xCD}) {
+		t.Errorf("unexpected fill %X", p[16:])
+	}
+	if bytes.Equal(p[8:16], o.payload(now)[8:16]) {
+		t.Error("nonce should differ between probes")
+	}
+}
+
+func TestOptionsPayload_DefaultFill(t *testing.T) {
+	p := (&Options{Size: 4}).payload(time.Now())
+	if len(p) != MinPayloadSize {
+		t.Errorf("expected size raised to %d, got %d", MinPayloadSize, len(p))
+	}
+	p = (&Options{Size: 18}).payload(time.Now())
+	if p[16] != 16 || p[17] != 17 {
+		t.Errorf("expected incrementing fill, got %X", p[16:])
+	}
+}
+
+func TestEchoReplyVerify(t *testing.T) {
+	sent := (&Options{Size: 32}).payload(time.Now())
+	pkt := marshalEchoRequest(1, 1, sent)
+	pkt[0] = TypeEchoReply
+	pkt[2], pkt[3] = 0, 0
+	csum := ipChecksum(pkt)
+	pkt[2], pkt[3] = byte(csum>>8), byte(csum)
+
+	r, ok := parseEchoResponse(FamilyIPv4, 1, pkt, net.ParseIP("192.0.2.7"))
+	if !ok {
+		t.Fatal("reply not recognised")
+	}
+	if err := r.verify(sent); err != nil {
+		t.Errorf("intact reply reported as %v", err)
+	}
+
+	pkt[len(pkt)-1] ^= 0xFF
+	r, _ = parseEchoResponse(FamilyIPv4, 1, pkt, net.ParseIP("192.0.2.7"))
+	if err := r.verify(sent); !errors.Is(err, ErrCorruptPayload) {
+		t.Errorf("expected bad checksum to be corrupt, got %v", err)
+	}
+
+	r.badChecksum = false
+	if err := r.verify(sent); !errors.Is(err, ErrCorruptPayload) {
+		t.Errorf("expected changed byte to be corrupt, got %v", err)
+	}
+	if err := verifyPayload(sent, sent[:20]); !errors.Is(err, ErrCorruptPayload) {
+		t.Errorf("expected truncated echo to be corrupt, got %v", err)
+	}
+}
+
+func TestStatisticsSummarize_Corrupted(t *testing.T) {
+	s := &Statistics{
+		Sent: 4,
+		Results: []Result{
+			{Seq: 1, Success: true, RTT: time.Millisecond},
+			{Seq: 2, Err: fmt.Errorf("%w: mismatch at byte 3", ErrCorruptPayload)},
+			{Seq: 3},
+			{Seq: 4, Success: true, RTT: time.Millisecond},
+		},
+	}
+	s.summarize()
+	if s.Received != 2 || s.Corrupted != 1 || s.PacketLoss != 25 {
+		t.Errorf("got received=%d corrupted=%d loss=%v", s.Received, s.Corrupted, s.PacketLoss)
+	}
+}
+
+func TestPinger_LoopbackLargePayload(t *testing.T) {
+	p := NewPinger("127.0.0.1")
+	p.Count = 1
+	p.Size = 4000
+	p.Pattern = []byte{0xFF, 0x00}
+	stats, err := p.Run()
+	if err != nil {
+		t.Skipf("cannot ping loopback: %v", err)
+	}
+	r := stats.Results[0]
+	if !r.Success || r.Size != 8+4000 {
+		t.Errorf("expected intact 4008-byte reply, got %+v", r)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Context support
+// ---------------------------------------------------------------------------
+
+func TestPingContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	r := PingContext(ctx, "127.0.0.1", &Options{Timeout: 5 * time.Second})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled ping took %v", elapsed)
+	}
+	if r.Success {
+		t.Fatal("cancelled ping should not succeed")
+	}
+	if !errors.Is(r.Err, context.Canceled) && !strings.HasPrefix(r.Err.Error(), "socket:") {
+		t.Errorf("expected context.Canceled, got %v", r.Err)
+	}
+}
+
+func TestPingContext_NilOptions(t *testing.T) {
+	r := PingContext(context.Background(), "127.0.0.1", nil)
+	if r.Err != nil && strings.HasPrefix(r.Err.Error(), "socket:") {
+		t.Skipf("cannot open ICMP socket: %v", r.Err)
+	}
+	if !r.Success {
+		t.Errorf("expected loopback reply, got %v", r.Err)
+	}
+}
+
+func TestPinger_RunContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	p := NewPinger("127.0.0.1")
+	p.Count = 0 // until stopped
+	p.Interval = 10 * time.Millisecond
+	stats, err := p.RunContext(ctx)
+	if stats == nil {
+		t.Skipf("cannot ping loopback: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if stats.Sent == 0 {
+		t.Error("expected probes before the deadline")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Source address, interface, TTL and TOS
+// ---------------------------------------------------------------------------
+
+func TestOptionsListen_RejectsBadSettings(t *testing.T) {
+	cases := []Options{
+		{TTL: 256},
+		{TOS: -1},
+		{Source: net.ParseIP("::1")},
+	}
+	for _, o := range cases {
+		if c, err := o.listen(FamilyIPv4); err == nil {
+			c.Close()
+			t.Errorf("%+v: expected error", o)
+		}
+	}
+}
+
+func TestOptionsResolve_SourceSelectsFamily(t *testing.T) {
+	o := Options{Source: net.ParseIP("::1")}
+	if _, _, err := o.resolve(context.Background(), "127.0.0.1"); err == nil {
+		t.Error("IPv6 source should restrict resolution to IPv6")
+	}
+	o.Source = net.ParseIP("127.0.0.1")
+	if _, fam, err := o.resolve(context.Background(), "127.0.0.1"); err != nil || fam != FamilyIPv4 {
+		t.Errorf("got %v, %v", fam, err)
+	}
+}
+
+// TestPingContext_ReceivedTTL checks that every socket type reports the TTL
+// or hop limit of replies. Socket types that cannot be opened are skipped.
+func TestPingContext_ReceivedTTL(t *testing.T) {
+	for _, c := range []struct {
+		target string
+		socket SocketType
+	}{
+		{"127.0.0.1", SocketRaw},
+		{"127.0.0.1", SocketDatagram},
+		{"::1", SocketRaw},
+		{"::1", SocketDatagram},
+	} {
+		fam := FamilyIPv4
+		if strings.Contains(c.target, ":") {
+			fam = FamilyIPv6
+		}
+		conn, err := listen(fam, c.socket, nil)
+		if err != nil {
+			t.Logf("%s %s: skipped: %v", c.target, c.socket, err)
+			continue
+		}
+		conn.Close()
+
+		r := PingContext(context.Background(), c.target, &Options{Socket: c.socket, TTL: 9, TOS: 0x20})
+		if !r.Success {
+			t.Errorf("%s %s: %v", c.target, c.socket, r.Err)
+			continue
+		}
+		if r.TTL <= 0 {
+			t.Errorf("%s %s: expected received TTL, got %d", c.target, c.socket, r.TTL)
+		}
+	}
+}
+
+func TestPingContext_SourceAndInterface(t *testing.T) {
+	opts := &Options{Source: net.ParseIP("127.0.0.1"), Interface: "lo"}
+	conn, err := opts.listen(FamilyIPv4)
+	if err != nil {
+		t.Skipf("cannot bind ICMP socket: %v", err)
+	}
+	conn.Close()
+	if r := PingContext(context.Background(), "127.0.0.1", opts); !r.Success {
+		t.Errorf("expected success, got %v", r.Err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Receive timestamps
+// ---------------------------------------------------------------------------
+
+func TestEchoReplyRTT_FromPayload(t *testing.T) {
+	sent := time.Unix(100, 0)
+	payload := (&Options{}).payload(sent)
+	r := echoReply{payload: payload, at: sent.Add(3 * time.Millisecond), clock: ClockKernel}
+	if got := r.rtt(payload, time.Unix(99, 0)); got != 3*time.Millisecond {
+		t.Errorf("expected RTT from the payload timestamp, got %v", got)
+	}
+	r.payload = append([]byte(nil), payload...)
+	r.payload[0] ^= 0xFF
+	if got := r.rtt(payload, sent.Add(time.Millisecond)); got != 2*time.Millisecond {
+		t.Errorf("corrupt payload: expected RTT from the send time, got %v", got)
+	}
+	r.payload, r.at = payload, sent.Add(-time.Second)
+	if got := r.rtt(payload, sent); got != 0 {
+		t.Errorf("clock stepped back: expected 0, got %v", got)
+	}
+}
+
+func TestEchoReplyRTT_UserClock(t *testing.T) {
+	sent := time.Now()
+	payload := (&Options{}).payload(sent)
+	r := echoReply{payload: payload, at: sent.Add(3 * time.Millisecond), clock: ClockUser}
+	if got := r.rtt(payload, sent); got != 3*time.Millisecond {
+		t.Errorf("expected RTT from the send time, got %v", got)
+	}
+}
+
+// TestPingContext_KernelTimestamp expects Linux sockets to deliver kernel
+// receive times. It is skipped when no ICMP socket can be opened.
+func TestPingContext_KernelTimestamp(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel timestamps are only requested on Linux")
+	}
+	r := PingContext(context.Background(), "127.0.0.1", nil)
+	if !r.Success {
+		t.Skipf("cannot ping loopback: %v", r.Err)
+	}
+	if r.Clock != ClockKernel {
+		t.Errorf("expected kernel receive time, got %v", r.Clock)
+	}
+}
+
+func TestStatisticsSummarize_ReplyKinds(t *testing.T) {
+	s := &Statistics{Sent: 3, Results: []Result{
+		{Success: true, Kind: ReplyReordered, Duplicates: 2},
+		{Success: true, Kind: ReplyNormal},
+		{Kind: ReplyLate, Err: errors.New("timeout")},
+	}}
+	s.summarize()
+	if s.Reordered != 1 || s.Late != 1 || s.Duplicates != 2 || s.Received != 2 {
+		t.Errorf("unexpected statistics %+v", s)
+	}
+}
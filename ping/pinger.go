@@ -0,0 +1,474 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default settings used by NewPinger, matching ping(8).
+const (
+	DefaultCount    = 4
+	DefaultInterval = time.Second
+	DefaultTimeout  = time.Second
+)
+
+// idCounter makes echo IDs unique per Pinger within one process, so that
+// several sessions sharing the host's raw ICMP traffic do not steal each
+// other's replies.
+var idCounter uint32
+
+func nextID() uint16 {
+	return uint16(os.Getpid()) + uint16(atomic.AddUint32(&idCounter, 1))
+}
+
+// runControl holds the echo ID and stop channel of a Pinger, Sweeper,
+// Engine or Monitor. Both are created on first use, so that values built as
+// struct literals work like those returned by the constructors.
+type runControl struct {
+	initOnce sync.Once
+	stopOnce sync.Once
+	id       uint16
+	stop     chan struct{}
+}
+
+func (c *runControl) init() {
+	c.initOnce.Do(func() {
+		c.id = nextID()
+		c.stop = make(chan struct{})
+	})
+}
+
+// echoID returns the echo ID of the run.
+func (c *runControl) echoID() uint16 {
+	c.init()
+	return c.id
+}
+
+// stopped returns a channel that is closed by halt.
+func (c *runControl) stopped() <-chan struct{} {
+	c.init()
+	return c.stop
+}
+
+// halt closes the stop channel; later calls do nothing.
+func (c *runControl) halt() {
+	c.init()
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Statistics summarises a ping session in the style of ping(8).
+type Statistics struct {
+	Target     string // target as given by the caller
+	IP         string // resolved address that was probed
+	Sent       int
+	Received   int
+	PacketLoss float64 // percentage of probes without any reply
+	MinRTT     time.Duration
+	AvgRTT     time.Duration
+	MaxRTT     time.Duration
+	MdevRTT    time.Duration // standard deviation of the RTTs
+	Corrupted  int           // replies whose payload was not echoed intact
+	Duplicates int           // extra replies to already answered probes
+	Late       int           // probes answered after their timeout
+	Reordered  int           // probes answered after a later probe
+	Latency    Latency       // RTT percentiles, jitter and histogram
+	Results    []Result      // per-probe outcomes, ordered by sequence
+}
+
+// ReplyKind classifies a reply within a session.
+type ReplyKind int
+
+const (
+	// ReplyNone marks a probe that has not been answered.
+	ReplyNone ReplyKind = iota
+	// ReplyNormal is the first reply to a probe, in sequence.
+	ReplyNormal
+	// ReplyReordered is the first reply to a probe, arriving after the
+	// reply to a later probe.
+	ReplyReordered
+	// ReplyLate is the first reply to a probe that had already timed out.
+	// The probe still counts as lost.
+	ReplyLate
+	// ReplyDuplicate is any further reply to a probe (ping's "DUP!").
+	ReplyDuplicate
+)
+
+func (k ReplyKind) String() string {
+	switch k {
+	case ReplyNormal:
+		return "normal"
+	case ReplyReordered:
+		return "reordered"
+	case ReplyLate:
+		return "late"
+	case ReplyDuplicate:
+		return "duplicate"
+	default:
+		return "none"
+	}
+}
+
+// Pinger sends a series of ICMP echo requests to one target over a single
+// socket. Configure the exported fields before calling Run.
+type Pinger struct {
+	Options
+	Target   string
+	Count    int           // number of probes; <= 0 runs until Stop is called
+	Interval time.Duration // delay between two probes
+
+	// OnReply, when set, is called with the outcome of every reply as it
+	// arrives, duplicates included. It runs on the session's goroutine and
+	// should return quickly.
+	OnReply func(Result)
+
+	ctl runControl
+}
+
+// NewPinger returns a Pinger for target with ping(8)-like defaults.
+func NewPinger(target string) *Pinger {
+	return &Pinger{
+		Options:  Options{Timeout: DefaultTimeout},
+		Target:   target,
+		Count:    DefaultCount,
+		Interval: DefaultInterval,
+	}
+}
+
+// Stop ends a running session. Probes still awaiting a reply are counted as
+// lost. It is safe to call Stop more than once and from any goroutine; a
+// stopped Pinger cannot be run again.
+func (p *Pinger) Stop() {
+	p.ctl.halt()
+}
+
+// echoReply is a response matched to this session by the receiver: an echo
+// reply, or an ICMP error quoting one of our requests when err is set. from
+// is always the probed address.
+type echoReply struct {
+	seq   uint16
+	from  net.IP
+	size  int
+	ttl   int
+	at    time.Time
+	clock Clock // source of at
+	err   error
+
+	payload     []byte // echoed payload of an echo reply
+	badChecksum bool
+}
+
+// rtt returns the round trip time of an echo reply to a request carrying
+// payload and sent at sent. Replies read in user space are measured on the
+// monotonic clock from sent. Kernel timestamps carry no monotonic reading,
+// so they are measured from the send time embedded in the echoed payload,
+// unless the payload did not come back intact, and never come out
+// negative.
+func (r *echoReply) rtt(payload []byte, sent time.Time) time.Duration {
+	if r.clock == ClockUser {
+		return r.at.Sub(sent)
+	}
+	d := r.at.Sub(sent)
+	if ts, ok := payloadTime(r.payload); ok && verifyPayload(payload, r.payload) == nil {
+		d = r.at.Sub(ts)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// verify checks that an echo reply returned the payload sent intact.
+func (r *echoReply) verify(sent []byte) error {
+	if r.badChecksum {
+		return fmt.Errorf("%w: bad checksum", ErrCorruptPayload)
+	}
+	return verifyPayload(sent, r.payload)
+}
+
+// probe tracks one outstanding echo request.
+type probe struct {
+	index    int // position in Statistics.Results
+	sent     time.Time
+	deadline time.Time
+	payload  []byte
+}
+
+// Run sends the configured probes and blocks until every reply has arrived
+// or timed out, or until Stop is called. Raw sockets need root or
+// CAP_NET_RAW; see SocketType for the unprivileged alternative.
+func (p *Pinger) Run() (*Statistics, error) {
+	return p.RunContext(context.Background())
+}
+
+// RunContext is like Run but also ends the session when ctx is done. The
+// statistics gathered so far are returned together with the context's
+// error; probes still awaiting a reply are counted as lost.
+func (p *Pinger) RunContext(ctx context.Context) (*Statistics, error) {
+	if p.Interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	if p.Timeout < 0 {
+		return nil, errors.New("timeout must not be negative")
+	}
+
+	raddr, fam, err := p.resolve(ctx, p.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := p.listen(fam)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	defer conn.Close()
+	id := conn.EchoID(p.ctl.echoID())
+
+	var src net.IP
+	if fam == FamilyIPv6 {
+		src = p.source(raddr.IP)
+	}
+
+	replies := make(chan echoReply)
+	done := make(chan struct{})
+	defer close(done)
+	go receiveEcho(conn, fam, id, raddr.IP, replies, done)
+
+	stats := &Statistics{Target: p.Target, IP: raddr.IP.String()}
+	pending := make(map[uint16]*probe)
+	settled := make(map[uint16]*probe) // answered or timed out
+	answered := -1                     // highest result index answered so far
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	var seq uint16
+	send := func() {
+		seq++
+		delete(settled, seq) // the sequence number wrapped around
+		now := time.Now()
+		stats.Results = append(stats.Results, Result{IP: stats.IP, Seq: int(seq)})
+		stats.Sent++
+		payload := p.payload(now)
+		if _, err := conn.WriteTo(buildEcho(fam, id, seq, payload, src, raddr.IP), raddr); err != nil {
+			stats.Results[len(stats.Results)-1].Err = fmt.Errorf("send: %w", err)
+			return
+		}
+		pending[seq] = &probe{index: len(stats.Results) - 1, sent: now, deadline: now.Add(p.timeout()), payload: payload}
+	}
+
+	reply := func(r echoReply) {
+		if pr, ok := pending[r.seq]; ok {
+			delete(pending, r.seq)
+			settled[r.seq] = pr
+			res := &stats.Results[pr.index]
+			res.Kind = ReplyNormal
+			if pr.index < answered {
+				res.Kind = ReplyReordered
+			} else {
+				answered = pr.index
+			}
+			r.apply(res, pr)
+			p.notify(*res)
+			return
+		}
+
+		pr, ok := settled[r.seq]
+		if !ok {
+			return
+		}
+		res := &stats.Results[pr.index]
+		if res.Kind == ReplyNone {
+			// The probe timed out: record the reply but keep the probe
+			// lost.
+			late := *res
+			late.Kind = ReplyLate
+			r.apply(&late, pr)
+			late.Success, late.Err = false, res.Err
+			*res = late
+			p.notify(late)
+			return
+		}
+		res.Duplicates++
+		dup := Result{IP: res.IP, Seq: res.Seq, Kind: ReplyDuplicate}
+		r.apply(&dup, pr)
+		p.notify(dup)
+	}
+
+	// timer fires at the earliest deadline of the pending probes. It is
+	// replaced on every iteration and stopped once the select is done.
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	send()
+	for {
+		allSent := p.Count > 0 && stats.Sent >= p.Count
+		if allSent && len(pending) == 0 {
+			break
+		}
+
+		var tick <-chan time.Time
+		if !allSent {
+			tick = ticker.C
+		}
+
+		var expire <-chan time.Time
+		timer = nil
+		if next, ok := earliestDeadline(pending); ok {
+			timer = time.NewTimer(time.Until(next))
+			expire = timer.C
+		}
+
+		select {
+		case <-p.ctl.stopped():
+			for _, pr := range pending {
+				stats.Results[pr.index].Err = fmt.Errorf("recv: %w", os.ErrDeadlineExceeded)
+			}
+			stats.summarize()
+			return stats, nil
+
+		case <-ctx.Done():
+			for _, pr := range pending {
+				stats.Results[pr.index].Err = fmt.Errorf("recv: %w", ctx.Err())
+			}
+			stats.summarize()
+			return stats, ctx.Err()
+
+		case <-tick:
+			send()
+
+		case r := <-replies:
+			reply(r)
+
+		case now := <-expire:
+			for s, pr := range pending {
+				if !now.Before(pr.deadline) {
+					stats.Results[pr.index].Err = fmt.Errorf("recv: %w", os.ErrDeadlineExceeded)
+					delete(pending, s)
+					settled[s] = pr
+				}
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	stats.summarize()
+	return stats, nil
+}
+
+// notify passes the outcome of a reply to OnReply.
+func (p *Pinger) notify(r Result) {
+	if p.OnReply != nil {
+		p.OnReply(r)
+	}
+}
+
+// apply records the reply to the probe pr in res.
+func (r *echoReply) apply(res *Result, pr *probe) {
+	if r.err != nil {
+		res.Err = r.err
+		return
+	}
+	res.Err = r.verify(pr.payload)
+	res.Success = res.Err == nil
+	res.RTT = r.rtt(pr.payload, pr.sent)
+	res.Clock = r.clock
+	res.Size = r.size
+	res.TTL = r.ttl
+}
+
+// receiveEcho reads packets of family fam from conn until done is closed or
+// the transport fails, forwarding responses to echo requests that carry the
+// given ID. When dst is not nil, responses concerning other addresses are
+// dropped.
+func receiveEcho(conn Conn, fam Family, id uint16, dst net.IP, replies chan<- echoReply, done <-chan struct{}) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		msg, info, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		at, clock := info.received()
+
+		r, ok := parseEchoResponse(fam, id, msg, info.From)
+		if !ok || (dst != nil && !r.from.Equal(dst)) {
+			continue
+		}
+		r.ttl, r.at, r.clock = info.TTL, at, clock
+
+		select {
+		case replies <- r:
+		case <-done:
+			return
+		}
+	}
+}
+
+// earliestDeadline returns the soonest deadline among the pending probes.
+func earliestDeadline(pending map[uint16]*probe) (time.Time, bool) {
+	var next time.Time
+	for _, pr := range pending {
+		if next.IsZero() || pr.deadline.Before(next) {
+			next = pr.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// summarize fills in the loss and RTT figures from the per-probe results.
+// Corrupted replies count neither as received nor as lost.
+func (s *Statistics) summarize() {
+	s.Received, s.Corrupted = 0, 0
+	s.Duplicates, s.Late, s.Reordered = 0, 0, 0
+	var sum, sumSq float64
+	latency := NewLatencyRecorder()
+	for _, r := range s.Results {
+		s.Duplicates += r.Duplicates
+		switch r.Kind {
+		case ReplyLate:
+			s.Late++
+		case ReplyReordered:
+			s.Reordered++
+		}
+		if errors.Is(r.Err, ErrCorruptPayload) {
+			s.Corrupted++
+		}
+		if !r.Success {
+			continue
+		}
+		if s.Received == 0 || r.RTT < s.MinRTT {
+			s.MinRTT = r.RTT
+		}
+		if r.RTT > s.MaxRTT {
+			s.MaxRTT = r.RTT
+		}
+		rtt := float64(r.RTT)
+		sum += rtt
+		sumSq += rtt * rtt
+		s.Received++
+		latency.Add(r.RTT)
+	}
+	s.Latency = latency.Latency()
+
+	if s.Sent > 0 {
+		s.PacketLoss = float64(s.Sent-s.Received-s.Corrupted) / float64(s.Sent) * 100
+	}
+	if s.Received > 0 {
+		avg := sum / float64(s.Received)
+		s.AvgRTT = time.Duration(avg)
+		// ping(8) reports mdev as sqrt(E[rtt^2] - E[rtt]^2).
+		s.MdevRTT = time.Duration(math.Sqrt(math.Max(sumSq/float64(s.Received)-avg*avg, 0)))
+	}
+}
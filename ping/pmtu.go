@@ -0,0 +1,179 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxMTU is the largest packet size DiscoverMTU tries by default,
+// matching common jumbo-frame configurations.
+const DefaultMaxMTU = 9000
+
+// Minimum MTUs every link must support (RFC 791, RFC 8200).
+const (
+	minMTUv4 = 68
+	minMTUv6 = 1280
+)
+
+// MTUOptions configures DiscoverMTU. Sizes are whole IP packets, headers
+// included. The embedded Options.Timeout bounds the wait for each probe.
+type MTUOptions struct {
+	Options
+	MinMTU   int // smallest size tried; <= 0 means 68 (IPv4) or 1280 (IPv6)
+	MaxMTU   int // largest size tried; <= 0 means DefaultMaxMTU
+	Attempts int // unanswered probes before a size counts as too big; <= 0 means 2
+}
+
+// PathMTU is the result of DiscoverMTU.
+type PathMTU struct {
+	Target string // target as given by the caller
+	IP     string // resolved address that was probed
+	MTU    int    // largest packet size that reached the target and came back
+	From   string // router that last reported a smaller next-hop MTU, if any
+	Probes int    // echo requests sent during the search
+}
+
+// DiscoverMTU finds the path MTU to target. Echo requests are sent with the
+// Don't Fragment flag set while their size is binary searched between the
+// configured bounds; fragmentation needed (ICMPv4) and packet too big
+// (ICMPv6) answers narrow the search to the MTU reported by the router.
+// Sizes that time out count as too big, which also covers paths where such
+// messages are filtered. A nil opts uses the defaults.
+func DiscoverMTU(target string, opts *MTUOptions) (*PathMTU, error) {
+	return DiscoverMTUContext(context.Background(), target, opts)
+}
+
+// DiscoverMTUContext is like DiscoverMTU but gives up when ctx is done,
+// returning the context's error.
+func DiscoverMTUContext(ctx context.Context, target string, opts *MTUOptions) (*PathMTU, error) {
+	if opts == nil {
+		opts = &MTUOptions{}
+	}
+
+	raddr, fam, err := opts.resolve(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	hdrLen, lo, hi, attempts := 20, opts.MinMTU, opts.MaxMTU, opts.Attempts
+	if lo <= 0 {
+		lo = minMTUv4
+	}
+	if fam == FamilyIPv6 {
+		hdrLen = 40
+		if opts.MinMTU <= 0 {
+			lo = minMTUv6
+		}
+	}
+	if hi <= 0 {
+		hi = DefaultMaxMTU
+	}
+	if attempts <= 0 {
+		attempts = 2
+	}
+	if lo < hdrLen+16 || lo > hi {
+		return nil, fmt.Errorf("invalid MTU bounds %d-%d", lo, hi)
+	}
+
+	conn, err := opts.listen(fam)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	defer conn.Close()
+	defer watch(ctx, conn)()
+	sc, err := socketOptions(conn)
+	if err == nil {
+		err = sc.setDontFragment()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+
+	var src net.IP
+	if fam == FamilyIPv6 {
+		src = opts.source(raddr.IP)
+	}
+
+	pm := &PathMTU{Target: target, IP: raddr.IP.String()}
+	id := conn.EchoID(nextID())
+	var seq uint16
+	buf := make([]byte, hi) // echo replies are as large as the request
+
+	// probe reports whether a packet of size bytes makes it to the target
+	// and back, and the next-hop MTU when a router says it does not.
+	probe := func(size int) (fits bool, hint int, err error) {
+		for attempt := 0; attempt < attempts; attempt++ {
+			seq++
+			pm.Probes++
+			now := time.Now()
+			pkt := buildEcho(fam, id, seq, timestampPayload(now, size-hdrLen-8), src, raddr.IP)
+			if _, err := conn.WriteTo(pkt, raddr); err != nil {
+				if errors.Is(err, syscall.EMSGSIZE) {
+					// Larger than the MTU of the outgoing interface.
+					return false, 0, nil
+				}
+				return false, 0, fmt.Errorf("send: %w", err)
+			}
+
+			setReadDeadlineContext(ctx, conn, now.Add(opts.timeout()))
+			for {
+				msg, info, err := conn.ReadFrom(buf)
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return false, 0, ctxErr
+				}
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				if err != nil {
+					return false, 0, fmt.Errorf("recv: %w", err)
+				}
+				r, ok := parseEchoResponse(fam, id, msg, info.From)
+				if !ok || r.seq != seq || !r.from.Equal(raddr.IP) {
+					continue
+				}
+				var tooBig *PacketTooBigError
+				switch {
+				case r.err == nil:
+					return true, 0, nil
+				case errors.As(r.err, &tooBig):
+					pm.From = tooBig.From.String()
+					return false, tooBig.MTU, nil
+				default:
+					return false, 0, r.err
+				}
+			}
+		}
+		return false, 0, nil
+	}
+
+	fits, _, err := probe(lo)
+	if err != nil {
+		return pm, err
+	}
+	if !fits {
+		return pm, fmt.Errorf("no reply to %d-byte probes", lo)
+	}
+
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		fits, hint, err := probe(mid)
+		if err != nil {
+			return pm, err
+		}
+		if fits {
+			lo = mid
+			continue
+		}
+		hi = mid - 1
+		if hint >= lo && hint < hi {
+			hi = hint
+		}
+	}
+	pm.MTU = lo
+	return pm, nil
+}
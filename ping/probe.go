@@ -0,0 +1,197 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Default ports of the TCP and UDP probe methods: SMB, which the hosts we
+// watch serve, and the first traceroute port, which is rarely open.
+const (
+	DefaultTCPPort = 445
+	DefaultUDPPort = 33434
+)
+
+// Method selects how a host is probed.
+type Method int
+
+const (
+	// MethodICMP sends an ICMP echo request.
+	MethodICMP Method = iota
+	// MethodTCP opens a TCP connection. An accepted connection and a reset
+	// both prove that the host is alive.
+	MethodTCP
+	// MethodUDP sends a UDP datagram. Any answer, including an ICMP port
+	// unreachable error, proves that the host is alive.
+	MethodUDP
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodTCP:
+		return "tcp"
+	case MethodUDP:
+		return "udp"
+	default:
+		return "icmp"
+	}
+}
+
+// ProbeMethod is a probe method together with the port it targets.
+type ProbeMethod struct {
+	Method Method
+	Port   int // TCP or UDP port; 0 means DefaultTCPPort or DefaultUDPPort
+}
+
+func (m ProbeMethod) port() int {
+	switch {
+	case m.Port > 0:
+		return m.Port
+	case m.Method == MethodUDP:
+		return DefaultUDPPort
+	default:
+		return DefaultTCPPort
+	}
+}
+
+// Probe checks whether target is alive with the given method. It is
+// ProbeContext without a context.
+func Probe(target string, m ProbeMethod, opts *Options) Result {
+	return ProbeContext(context.Background(), target, m, opts)
+}
+
+// ProbeContext checks whether target is alive with the given method and
+// returns the outcome in the same shape as PingContext, waiting up to
+// opts.Timeout; a nil opts uses the defaults. MethodICMP probes like
+// PingContext, without falling back to other methods. TCP and UDP results
+// carry the RTT of the handshake or exchange, but no TTL; UDP results that
+// got a reply datagram also carry its payload size.
+func ProbeContext(ctx context.Context, target string, m ProbeMethod, opts *Options) Result {
+	if opts == nil {
+		opts = &Options{}
+	}
+	switch m.Method {
+	case MethodTCP:
+		return probeTCP(ctx, target, m.port(), opts)
+	case MethodUDP:
+		return probeUDP(ctx, target, m.port(), opts)
+	default:
+		return pingEcho(ctx, target, opts)
+	}
+}
+
+// fallback tries the methods of opts.Fallback in turn after an ICMP probe
+// of target timed out, and returns the first successful result, or the last
+// one when none succeeds.
+func fallback(ctx context.Context, target string, r Result, opts *Options) Result {
+	if !errors.Is(r.Err, os.ErrDeadlineExceeded) {
+		return r
+	}
+	for _, m := range opts.Fallback {
+		if m.Method == MethodICMP {
+			continue
+		}
+		r = ProbeContext(ctx, target, m, opts)
+		if r.Success || ctx.Err() != nil {
+			break
+		}
+	}
+	return r
+}
+
+// dialer returns a dialer for the TCP or UDP method that sends from the
+// configured source address and interface. TTL and TOS only apply to ICMP.
+func (o *Options) dialer(m Method) *net.Dialer {
+	d := &net.Dialer{}
+	if o.Source != nil {
+		if m == MethodUDP {
+			d.LocalAddr = &net.UDPAddr{IP: o.Source}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: o.Source}
+		}
+	}
+	if iface := o.Interface; iface != "" {
+		d.Control = func(_, _ string, rc syscall.RawConn) error {
+			return bindRawConnToDevice(rc, iface)
+		}
+	}
+	return d
+}
+
+// probeTCP connects to port on target. The connection is closed right away.
+func probeTCP(ctx context.Context, target string, port int, opts *Options) Result {
+	raddr, _, err := opts.resolve(ctx, target)
+	if err != nil {
+		return Result{IP: target, Method: MethodTCP, Err: err}
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, opts.timeout())
+	defer cancel()
+
+	start := time.Now()
+	c, err := opts.dialer(MethodTCP).DialContext(dctx, "tcp", net.JoinHostPort(raddr.IP.String(), strconv.Itoa(port)))
+	rtt := time.Since(start)
+	if err == nil {
+		c.Close()
+	}
+
+	r := Result{IP: target, Seq: 1, Method: MethodTCP}
+	switch {
+	case err == nil, errors.Is(err, syscall.ECONNREFUSED):
+		r.Success, r.RTT = true, rtt
+	case ctx.Err() != nil:
+		r.Err = fmt.Errorf("connect: %w", ctx.Err())
+	case dctx.Err() != nil:
+		r.Err = fmt.Errorf("connect: %w", os.ErrDeadlineExceeded)
+	default:
+		r.Err = fmt.Errorf("connect: %w", err)
+	}
+	return r
+}
+
+// probeUDP sends the probe payload to port on target and waits for any
+// answer. A closed port answers with an ICMP port unreachable error, which
+// the connected socket reports as a refused connection.
+func probeUDP(ctx context.Context, target string, port int, opts *Options) Result {
+	raddr, _, err := opts.resolve(ctx, target)
+	if err != nil {
+		return Result{IP: target, Method: MethodUDP, Err: err}
+	}
+
+	c, err := opts.dialer(MethodUDP).DialContext(ctx, "udp", net.JoinHostPort(raddr.IP.String(), strconv.Itoa(port)))
+	if err != nil {
+		return Result{IP: target, Method: MethodUDP, Err: fmt.Errorf("socket: %w", err)}
+	}
+	defer c.Close()
+	defer context.AfterFunc(ctx, func() { _ = c.SetReadDeadline(aLongTimeAgo) })()
+
+	start := time.Now()
+	if _, err := c.Write(opts.payload(start)); err != nil {
+		return Result{IP: target, Method: MethodUDP, Err: fmt.Errorf("send: %w", err)}
+	}
+	_ = c.SetReadDeadline(start.Add(opts.timeout()))
+	if ctx.Err() != nil {
+		_ = c.SetReadDeadline(aLongTimeAgo)
+	}
+
+	buf := make([]byte, maxPacketSize)
+	n, err := c.Read(buf)
+	rtt := time.Since(start)
+
+	r := Result{IP: target, Seq: 1, Method: MethodUDP}
+	switch {
+	case err == nil:
+		r.Success, r.RTT, r.Size = true, rtt, n
+	case errors.Is(err, syscall.ECONNREFUSED):
+		r.Success, r.RTT = true, rtt
+	default:
+		r.Err = fmt.Errorf("recv: %w", contextError(ctx, err))
+	}
+	return r
+}
@@ -0,0 +1,108 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// localPort returns the port of a local address.
+func localPort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.Port
+	case *net.UDPAddr:
+		return a.Port
+	}
+	return 0
+}
+
+func TestProbeTCP_OpenAndClosedPorts(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	port := localPort(ln.Addr())
+	r := Probe("127.0.0.1", ProbeMethod{Method: MethodTCP, Port: port}, nil)
+	if !r.Success || r.Method != MethodTCP || r.RTT <= 0 {
+		t.Errorf("open port: unexpected result %+v", r)
+	}
+
+	// A reset from the closed port proves the host is alive as well.
+	ln.Close()
+	r = Probe("127.0.0.1", ProbeMethod{Method: MethodTCP, Port: port}, nil)
+	if !r.Success {
+		t.Errorf("closed port: unexpected result %+v", r)
+	}
+}
+
+func TestProbeUDP_ReplyAndPortUnreachable(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		n, from, err := pc.ReadFrom(buf)
+		if err == nil {
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	port := localPort(pc.LocalAddr())
+	r := Probe("127.0.0.1", ProbeMethod{Method: MethodUDP, Port: port}, &Options{Timeout: time.Second})
+	if !r.Success || r.Method != MethodUDP || r.Size != MinPayloadSize {
+		t.Errorf("echo: unexpected result %+v", r)
+	}
+
+	pc.Close()
+	r = Probe("127.0.0.1", ProbeMethod{Method: MethodUDP, Port: port}, &Options{Timeout: time.Second})
+	if !r.Success {
+		t.Errorf("port unreachable: unexpected result %+v", r)
+	}
+}
+
+func TestProbeUDP_Timeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer pc.Close()
+	r := Probe("127.0.0.1", ProbeMethod{Method: MethodUDP, Port: localPort(pc.LocalAddr())}, &Options{Timeout: 20 * time.Millisecond})
+	if r.Success || !errors.Is(r.Err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected timeout, got %+v", r)
+	}
+}
+
+func TestPingContext_FallbackAfterTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer ln.Close()
+
+	conn := newFakeConn(FamilyIPv4, func([]byte, net.IP, int) []fakePacket { return nil })
+	opts := &Options{
+		Timeout:  20 * time.Millisecond,
+		Listen:   conn.listen,
+		Fallback: []ProbeMethod{{Method: MethodICMP}, {Method: MethodTCP, Port: localPort(ln.Addr())}},
+	}
+	r := PingContext(context.Background(), "127.0.0.1", opts)
+	if !r.Success || r.Method != MethodTCP {
+		t.Errorf("expected TCP fallback to succeed, got %+v", r)
+	}
+}
+
+func TestPingContext_NoFallbackOnICMPError(t *testing.T) {
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		return []fakePacket{errorFrom(TypeDestinationUnreachable, 1, req, dst, net.ParseIP("198.51.100.1"))}
+	})
+	opts := &Options{Listen: conn.listen, Fallback: []ProbeMethod{{Method: MethodTCP, Port: 1}}}
+	r := PingContext(context.Background(), "127.0.0.1", opts)
+	var ue *UnreachableError
+	if r.Method != MethodICMP || !errors.As(r.Err, &ue) {
+		t.Errorf("expected the ICMP error, got %+v", r)
+	}
+}
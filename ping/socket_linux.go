@@ -0,0 +1,169 @@
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// listenDatagram opens an unprivileged ICMP socket (SOCK_DGRAM with
+// IPPROTO_ICMP/IPPROTO_ICMPV6). The kernel binds it to an identifier that
+// it substitutes for the echo ID of outgoing requests and uses to route
+// replies back, so replies for other processes are never delivered.
+// The socket is bound to src unless it is nil.
+func listenDatagram(fam Family, src net.IP) (*packetConn, error) {
+	domain, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	sa4 := &syscall.SockaddrInet4{}
+	copy(sa4.Addr[:], src.To4())
+	var sa syscall.Sockaddr = sa4
+	if fam == FamilyIPv6 {
+		domain, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], src.To16())
+		sa = sa6
+	}
+
+	fd, err := syscall.Socket(domain, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// FilePacketConn duplicates the descriptor, so the original is closed.
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint16(c.LocalAddr().(*net.UDPAddr).Port)
+	return &packetConn{c: c, family: fam, typ: SocketDatagram, id: id}, nil
+}
+
+// setTTL sets the TTL (IPv4) or unicast hop limit (IPv6) of outgoing packets.
+func (c *packetConn) setTTL(ttl int) error {
+	if c.family == FamilyIPv6 {
+		return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// ipv6DontFrag is IPV6_DONTFRAG from <linux/in6.h>, missing from syscall.
+const ipv6DontFrag = 62
+
+// setDontFragment sets the Don't Fragment flag on outgoing IPv4 packets, or
+// disables local fragmentation for IPv6, ignoring the kernel's path MTU
+// cache so that sizes above the known path MTU can still be probed.
+func (c *packetConn) setDontFragment() error {
+	if c.family == FamilyIPv6 {
+		if err := c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE); err != nil {
+			return err
+		}
+		return c.setsockoptInt(syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+}
+
+// setTOS sets the IPv4 type of service byte or the IPv6 traffic class of
+// outgoing packets.
+func (c *packetConn) setTOS(tos int) error {
+	if c.family == FamilyIPv6 {
+		return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
+
+// bindToDevice restricts the socket to the named interface.
+func (c *packetConn) bindToDevice(name string) error {
+	rc, err := c.rawConn()
+	if err != nil {
+		return err
+	}
+	return bindRawConnToDevice(rc, name)
+}
+
+// reportTTL makes the kernel attach the TTL (IPv4) or hop limit (IPv6) of
+// received packets as a control message. Raw IPv4 sockets need none, as
+// they deliver the IP header.
+func (c *packetConn) reportTTL() error {
+	if c.family == FamilyIPv6 {
+		return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+	}
+	if c.typ == SocketDatagram {
+		return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+	}
+	return nil
+}
+
+// reportTimestamps makes the kernel attach the receive time of packets as a
+// control message.
+func (c *packetConn) reportTimestamps() error {
+	return c.setsockoptInt(syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+}
+
+// parseControl returns the TTL or hop limit and the kernel receive time
+// carried by the control messages in oob, or zero values when absent.
+func parseControl(oob []byte) (ttl int, at time.Time) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	for _, m := range msgs {
+		h := m.Header
+		switch {
+		case (h.Level == syscall.IPPROTO_IP && h.Type == syscall.IP_TTL) ||
+			(h.Level == syscall.IPPROTO_IPV6 && h.Type == syscall.IPV6_HOPLIMIT):
+			if len(m.Data) >= 4 {
+				ttl = int(binary.NativeEndian.Uint32(m.Data))
+			}
+		case h.Level == syscall.SOL_SOCKET && h.Type == syscall.SCM_TIMESTAMPNS:
+			// struct timespec: two longs, 32 or 64 bits wide.
+			switch len(m.Data) {
+			case 16:
+				at = time.Unix(int64(binary.NativeEndian.Uint64(m.Data)), int64(binary.NativeEndian.Uint64(m.Data[8:])))
+			case 8:
+				at = time.Unix(int64(int32(binary.NativeEndian.Uint32(m.Data))), int64(int32(binary.NativeEndian.Uint32(m.Data[4:]))))
+			}
+		}
+	}
+	return ttl, at
+}
+
+// bindRawConnToDevice sets SO_BINDTODEVICE on the socket behind rc.
+func bindRawConnToDevice(rc syscall.RawConn, name string) error {
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.BindToDevice(int(fd), name)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+func (c *packetConn) setsockoptInt(level, opt, value int) error {
+	rc, err := c.rawConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+func (c *packetConn) rawConn() (syscall.RawConn, error) {
+	sc, ok := c.c.(syscall.Conn)
+	if !ok {
+		return nil, errNoSocketOptions
+	}
+	return sc.SyscallConn()
+}
@@ -0,0 +1,59 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// listenDatagram is only implemented on Linux.
+func listenDatagram(fam Family, src net.IP) (*packetConn, error) {
+	return nil, fmt.Errorf("datagram ICMP sockets: %w", errUnsupported)
+}
+
+// setTTL is only implemented on Linux.
+func (c *packetConn) setTTL(ttl int) error {
+	return fmt.Errorf("setting the TTL: %w", errUnsupported)
+}
+
+// setDontFragment is only implemented on Linux.
+func (c *packetConn) setDontFragment() error {
+	return fmt.Errorf("setting the Don't Fragment flag: %w", errUnsupported)
+}
+
+// setTOS is only implemented on Linux.
+func (c *packetConn) setTOS(tos int) error {
+	return fmt.Errorf("setting the type of service: %w", errUnsupported)
+}
+
+// bindToDevice is only implemented on Linux.
+func (c *packetConn) bindToDevice(name string) error {
+	return fmt.Errorf("binding to an interface: %w", errUnsupported)
+}
+
+// reportTTL is a no-op: datagram sockets are Linux only, and raw IPv4
+// sockets deliver the IP header.
+func (c *packetConn) reportTTL() error {
+	return nil
+}
+
+// reportTimestamps is only implemented on Linux.
+func (c *packetConn) reportTimestamps() error {
+	return fmt.Errorf("kernel timestamps: %w", errUnsupported)
+}
+
+// parseControl is only implemented on Linux.
+func parseControl(oob []byte) (ttl int, at time.Time) {
+	return 0, time.Time{}
+}
+
+// bindRawConnToDevice is only implemented on Linux.
+func bindRawConnToDevice(rc syscall.RawConn, name string) error {
+	return fmt.Errorf("binding to an interface: %w", errUnsupported)
+}
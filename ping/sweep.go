@@ -0,0 +1,363 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultMaxInFlight is the default bound on outstanding probes of a Sweeper.
+const DefaultMaxInFlight = 64
+
+// MaxSweepAddresses is the largest number of addresses a CIDR block in
+// Sweeper.Targets may hold: an IPv4 /16 or an IPv6 /112.
+const MaxSweepAddresses = 1 << 16
+
+// Sweeper probes many hosts once each. All probes of one address family go
+// out through a single shared socket whose single receive goroutine
+// demultiplexes replies by address, echo ID and sequence number.
+type Sweeper struct {
+	Options
+	Targets     []string // host names, addresses or CIDR blocks of up to MaxSweepAddresses
+	MaxInFlight int      // bound on outstanding probes; <= 0 means DefaultMaxInFlight
+
+	ctl runControl
+}
+
+// NewSweeper returns a Sweeper for the given targets with default options.
+func NewSweeper(targets ...string) *Sweeper {
+	return &Sweeper{
+		Options:     Options{Timeout: DefaultTimeout},
+		Targets:     targets,
+		MaxInFlight: DefaultMaxInFlight,
+	}
+}
+
+// Stop ends a running sweep. Targets not yet probed are skipped and results
+// for outstanding probes are discarded. It is safe to call Stop more than
+// once and from any goroutine.
+func (s *Sweeper) Stop() {
+	s.ctl.halt()
+}
+
+// sweepSpec is one parsed entry of Sweeper.Targets.
+type sweepSpec struct {
+	host    string
+	network *net.IPNet // set for CIDR blocks
+}
+
+// sweepProbe is an echo request registered with the collector. A probe with
+// failed set carries a final error result instead.
+type sweepProbe struct {
+	seq      uint16
+	addr     net.IP
+	sent     time.Time
+	deadline time.Time
+	payload  []byte
+	result   Result
+
+	failed     bool
+	registered bool // a failed probe whose seq is already pending
+}
+
+// Run validates the targets and starts the sweep in the background. Results
+// are delivered on the returned channel as probes complete; the channel is
+// closed once every target has been settled or the sweep was stopped.
+func (s *Sweeper) Run() (<-chan Result, error) {
+	return s.RunContext(context.Background())
+}
+
+// RunContext is like Run but also stops the sweep, as Stop does, when ctx
+// is done. Host names are resolved with ctx as well.
+func (s *Sweeper) RunContext(ctx context.Context) (<-chan Result, error) {
+	specs, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := s.MaxInFlight
+	if limit <= 0 {
+		limit = DefaultMaxInFlight
+	}
+	out := make(chan Result, limit)
+	go s.run(ctx, specs, limit, out)
+	return out, nil
+}
+
+// parse validates the options and the targets.
+func (s *Sweeper) parse() ([]sweepSpec, error) {
+	if s.Timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative")
+	}
+
+	specs := make([]sweepSpec, 0, len(s.Targets))
+	for _, t := range s.Targets {
+		if !strings.Contains(t, "/") {
+			specs = append(specs, sweepSpec{host: t})
+			continue
+		}
+		_, network, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, err
+		}
+		if s.Family != FamilyAuto && familyOf(network.IP) != s.Family {
+			return nil, fmt.Errorf("%s is not an %s network", t, s.Family)
+		}
+		if ones, bits := network.Mask.Size(); bits-ones > 16 {
+			return nil, fmt.Errorf("%s holds more than %d addresses", t, MaxSweepAddresses)
+		}
+		specs = append(specs, sweepSpec{network: network})
+	}
+	return specs, nil
+}
+
+// run drives one sweep: a sender goroutine registers probes, per-socket
+// receivers deliver replies, and this goroutine settles them into out.
+func (s *Sweeper) run(ctx context.Context, specs []sweepSpec, limit int, out chan<- Result) {
+	defer close(out)
+
+	probes := make(chan *sweepProbe)
+	replies := make(chan echoReply)
+	done := make(chan struct{})
+	slots := make(chan struct{}, limit)
+	conns := make(map[Family]Conn)
+
+	go s.send(ctx, specs, conns, probes, replies, slots, done)
+
+	open := collect(ctx, s.ctl.stopped(), probes, replies, slots, out)
+
+	// Let the sender finish before its sockets are closed.
+	close(done)
+	if open {
+		for range probes {
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// collect settles the probes a sender registers on probes with the matching
+// replies, or with a timeout, delivering their results to out and releasing
+// their slots. It returns once the sender closed probes and nothing is
+// pending, or early when stop is closed or ctx is done, even while blocked
+// on out; open reports whether probes still needs draining then.
+func collect(ctx context.Context, stop <-chan struct{}, probes <-chan *sweepProbe, replies <-chan echoReply, slots <-chan struct{}, out chan<- Result) (open bool) {
+	open = true
+	pending := make(map[uint16]*sweepProbe)
+	stopped := false
+	settle := func(r Result) {
+		select {
+		case out <- r:
+			<-slots
+		case <-stop:
+			stopped = true
+		case <-ctx.Done():
+			stopped = true
+		}
+	}
+
+	for sending := true; !stopped && (sending || len(pending) > 0); {
+		var expire <-chan time.Time
+		var timer *time.Timer
+		if next, ok := earliestSweepDeadline(pending); ok {
+			timer = time.NewTimer(time.Until(next))
+			expire = timer.C
+		}
+
+		select {
+		case <-stop:
+			sending = false
+			pending = nil
+
+		case <-ctx.Done():
+			sending = false
+			pending = nil
+
+		case pr, ok := <-probes:
+			switch {
+			case !ok:
+				sending = false
+				probes = nil
+				open = false
+			case pr.failed:
+				if pr.registered {
+					delete(pending, pr.seq)
+				}
+				settle(pr.result)
+			default:
+				pending[pr.seq] = pr
+			}
+
+		case r := <-replies:
+			if pr, ok := pending[r.seq]; ok && r.from.Equal(pr.addr) {
+				delete(pending, r.seq)
+				pr.result.Kind = ReplyNormal
+				if r.err != nil {
+					pr.result.Err = r.err
+				} else {
+					pr.result.Err = r.verify(pr.payload)
+					pr.result.Success = pr.result.Err == nil
+					pr.result.RTT = r.rtt(pr.payload, pr.sent)
+					pr.result.Clock = r.clock
+					pr.result.Size = r.size
+					pr.result.TTL = r.ttl
+				}
+				settle(pr.result)
+			}
+
+		case now := <-expire:
+			for seq, pr := range pending {
+				if !stopped && !now.Before(pr.deadline) {
+					delete(pending, seq)
+					pr.result.Err = fmt.Errorf("recv: %w", os.ErrDeadlineExceeded)
+					settle(pr.result)
+				}
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	return open
+}
+
+// send walks the targets, opening sockets on demand, and registers every
+// probe with the collector before it is written to the wire.
+func (s *Sweeper) send(ctx context.Context, specs []sweepSpec, conns map[Family]Conn, probes chan<- *sweepProbe, replies chan<- echoReply, slots chan struct{}, done <-chan struct{}) {
+	defer close(probes)
+
+	connErrs := make(map[Family]error)
+	var seq uint16
+
+	probe := func(name string, addr *net.IPAddr, resolveErr error) bool {
+		select {
+		case slots <- struct{}{}:
+		case <-done:
+			return false
+		}
+
+		fail := func(pr *sweepProbe) bool {
+			pr.failed = true
+			select {
+			case probes <- pr:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		if resolveErr != nil {
+			return fail(&sweepProbe{result: Result{IP: name, Err: resolveErr}})
+		}
+
+		fam := familyOf(addr.IP)
+		conn, ok := conns[fam]
+		if !ok && connErrs[fam] == nil {
+			var err error
+			if conn, err = s.listen(fam); err != nil {
+				connErrs[fam] = fmt.Errorf("socket: %w", err)
+			} else {
+				conns[fam] = conn
+				go receiveEcho(conn, fam, conn.EchoID(s.ctl.echoID()), nil, replies, done)
+			}
+		}
+		if conn == nil {
+			return fail(&sweepProbe{result: Result{IP: name, Err: connErrs[fam]}})
+		}
+
+		seq++
+		now := time.Now()
+		pr := &sweepProbe{
+			seq:      seq,
+			addr:     addr.IP,
+			sent:     now,
+			deadline: now.Add(s.timeout()),
+			payload:  s.payload(now),
+			result:   Result{IP: name, Seq: int(seq)},
+		}
+		select {
+		case probes <- pr:
+		case <-done:
+			return false
+		}
+
+		var src net.IP
+		if fam == FamilyIPv6 {
+			src = s.source(addr.IP)
+		}
+		pkt := buildEcho(fam, conn.EchoID(s.ctl.echoID()), seq, pr.payload, src, addr.IP)
+		if _, err := conn.WriteTo(pkt, addr); err != nil {
+			return fail(&sweepProbe{
+				seq:        seq,
+				registered: true,
+				result:     Result{IP: name, Seq: int(seq), Err: fmt.Errorf("send: %w", err)},
+			})
+		}
+		return true
+	}
+
+	for _, spec := range specs {
+		if spec.network == nil {
+			addr, _, err := s.resolve(ctx, spec.host)
+			if !probe(spec.host, addr, err) {
+				return
+			}
+			continue
+		}
+		first, last := hostRange(spec.network)
+		for ip := first; ; ip = nextIP(ip) {
+			if !probe(ip.String(), &net.IPAddr{IP: ip}, nil) {
+				return
+			}
+			if ip.Equal(last) {
+				break
+			}
+		}
+	}
+}
+
+// hostRange returns the first and last address of network to probe. As
+// with fping -g, the network and broadcast addresses of IPv4 subnets larger
+// than /31 are skipped.
+func hostRange(network *net.IPNet) (first, last net.IP) {
+	first = make(net.IP, len(network.IP))
+	last = make(net.IP, len(network.IP))
+	for i := range network.IP {
+		first[i] = network.IP[i] & network.Mask[i]
+		last[i] = first[i] | ^network.Mask[i]
+	}
+	if ones, bits := network.Mask.Size(); bits == 32 && ones <= 30 {
+		first[len(first)-1]++
+		last[len(last)-1]--
+	}
+	return first, last
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// earliestSweepDeadline returns the soonest deadline among pending probes.
+func earliestSweepDeadline(pending map[uint16]*sweepProbe) (time.Time, bool) {
+	var next time.Time
+	for _, pr := range pending {
+		if next.IsZero() || pr.deadline.Before(next) {
+			next = pr.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
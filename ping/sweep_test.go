@@ -0,0 +1,92 @@
+package ping
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostRange(t *testing.T) {
+	cases := []struct {
+		cidr        string
+		first, last string
+	}{
+		{"192.168.1.0/24", "192.168.1.1", "192.168.1.254"},
+		{"10.0.0.4/30", "10.0.0.5", "10.0.0.6"},
+		{"10.0.0.4/31", "10.0.0.4", "10.0.0.5"},
+		{"10.0.0.7/32", "10.0.0.7", "10.0.0.7"},
+		{"2001:db8::/126", "2001:db8::", "2001:db8::3"},
+	}
+	for _, c := range cases {
+		_, network, err := net.ParseCIDR(c.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, last := hostRange(network)
+		if first.String() != c.first || last.String() != c.last {
+			t.Errorf("%s: expected %s-%s, got %s-%s", c.cidr, c.first, c.last, first, last)
+		}
+	}
+}
+
+func TestNextIP_Carries(t *testing.T) {
+	got := nextIP(net.ParseIP("10.0.0.255").To4())
+	if got.String() != "10.0.1.0" {
+		t.Errorf("expected 10.0.1.0, got %s", got)
+	}
+}
+
+func TestSweeper_RejectsBadTargets(t *testing.T) {
+	if _, err := NewSweeper("10.0.0.0/33").Run(); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	s := NewSweeper("2001:db8::/64")
+	s.Family = FamilyIPv4
+	if _, err := s.Run(); err == nil {
+		t.Error("expected error for IPv6 network with IPv4 family")
+	}
+	for _, network := range []string{"0.0.0.0/0", "10.0.0.0/15", "2001:db8::/64"} {
+		if _, err := NewSweeper(network).Run(); err == nil {
+			t.Errorf("expected error for %s, which is too large", network)
+		}
+	}
+	if _, err := NewSweeper("10.0.0.0/16").parse(); err != nil {
+		t.Errorf("expected a /16 to be accepted: %v", err)
+	}
+}
+
+// TestSweeper_Loopback sweeps a few loopback addresses and one unresolvable
+// name. It is skipped when no ICMP socket can be opened.
+func TestSweeper_Loopback(t *testing.T) {
+	for _, fam := range []Family{FamilyIPv4, FamilyIPv6} {
+		c, err := listen(fam, SocketAuto, nil)
+		if err != nil {
+			t.Skipf("cannot open %s ICMP socket: %v", fam, err)
+		}
+		c.Close()
+	}
+
+	s := NewSweeper("127.0.0.0/30", "::1", "host.invalid")
+	s.MaxInFlight = 2
+	s.Timeout = 500 * time.Millisecond
+	results, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]Result)
+	for r := range results {
+		got[r.IP] = r
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 results, got %d: %v", len(got), got)
+	}
+	if got["host.invalid"].Err == nil {
+		t.Error("expected resolve error for host.invalid")
+	}
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "::1"} {
+		if r := got[ip]; !r.Success {
+			t.Errorf("%s: expected success, got %v", ip, r.Err)
+		}
+	}
+}
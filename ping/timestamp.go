@@ -0,0 +1,181 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ICMP timestamp type constants (RFC 792). There is no ICMPv6 equivalent.
+const (
+	TypeTimestamp      = 13
+	TypeTimestampReply = 14
+)
+
+// timestampNonStandard marks RFC 792 timestamps that are not milliseconds
+// since midnight UT.
+const timestampNonStandard = 1 << 31
+
+// TimestampResult is the outcome of an ICMP timestamp exchange. The remote
+// timestamps only have millisecond resolution, and the delays are measured
+// between two clocks, so they include the clock offset. Offset and Delay
+// assume that the path is symmetric, as NTP does.
+type TimestampResult struct {
+	IP string
+
+	// Originate, Receive and Transmit are the timestamps carried by the
+	// reply, in milliseconds since midnight UT.
+	Originate uint32
+	Receive   uint32
+	Transmit  uint32
+
+	RTT      time.Duration // local round trip time
+	Offset   time.Duration // remote clock minus local clock
+	Delay    time.Duration // round trip time minus time spent at the host
+	Outbound time.Duration // remote receive minus local send
+	Inbound  time.Duration // local receive minus remote transmit
+
+	Err error
+}
+
+// Timestamp sends one ICMP timestamp request to target and estimates the
+// offset of its clock from the local one. It is TimestampContext without a
+// context.
+func Timestamp(target string, opts *Options) TimestampResult {
+	return TimestampContext(context.Background(), target, opts)
+}
+
+// TimestampContext sends one ICMP timestamp request to target and waits up
+// to opts.Timeout for the reply; a nil opts uses the defaults. Timestamp
+// messages only exist for IPv4, and datagram sockets only carry echo
+// messages, so a raw socket is required. Hosts answering with non-standard
+// timestamps are reported as an error.
+func TimestampContext(ctx context.Context, target string, opts *Options) TimestampResult {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Family == FamilyIPv6 {
+		return TimestampResult{IP: target, Err: errors.New("ICMP timestamps require IPv4")}
+	}
+	if opts.Socket == SocketDatagram {
+		return TimestampResult{IP: target, Err: errors.New("ICMP timestamps require a raw socket")}
+	}
+
+	v4 := *opts
+	v4.Family = FamilyIPv4
+	v4.Socket = SocketRaw
+	raddr, _, err := v4.resolve(ctx, target)
+	if err != nil {
+		return TimestampResult{IP: target, Err: err}
+	}
+
+	conn, err := v4.listen(FamilyIPv4)
+	if err != nil {
+		return TimestampResult{IP: target, Err: fmt.Errorf("socket: %w", err)}
+	}
+	defer conn.Close()
+	defer watch(ctx, conn)()
+
+	id := conn.EchoID(nextID())
+	seq := uint16(1)
+	sent := time.Now()
+	if _, err := conn.WriteTo(buildTimestampRequest(id, seq, sent), raddr); err != nil {
+		return TimestampResult{IP: target, Err: fmt.Errorf("send: %w", err)}
+	}
+	setReadDeadlineContext(ctx, conn, sent.Add(opts.timeout()))
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		msg, info, err := conn.ReadFrom(buf)
+		if err != nil {
+			return TimestampResult{IP: target, Err: fmt.Errorf("recv: %w", contextError(ctx, err))}
+		}
+		at, _ := info.received()
+
+		if len(msg) < 1 || msg[0] != TypeTimestampReply || !info.From.Equal(raddr.IP) {
+			continue
+		}
+		rid, rseq, originate, receive, transmit, err := ParseTimestamp(msg)
+		if err != nil || rid != id || rseq != seq {
+			continue
+		}
+
+		r := TimestampResult{IP: target, Originate: originate, Receive: receive, Transmit: transmit, RTT: at.Sub(sent)}
+		if receive&timestampNonStandard != 0 || transmit&timestampNonStandard != 0 {
+			r.Err = errors.New("host reported non-standard timestamps")
+			return r
+		}
+		r.Offset, r.Delay, r.Outbound, r.Inbound = clockOffset(sinceMidnight(sent), msDuration(receive), msDuration(transmit), sinceMidnight(at))
+		return r
+	}
+}
+
+// buildTimestampRequest creates a serialised ICMP timestamp request whose
+// originate timestamp is now.
+func buildTimestampRequest(id, seq uint16, now time.Time) []byte {
+	m := Message{Type: TypeTimestamp, Body: &TimestampBody{
+		ID:        id,
+		Seq:       seq,
+		Originate: uint32(sinceMidnight(now) / time.Millisecond),
+	}}
+	pkt, _ := m.Marshal(nil, nil) // timestamp bodies always marshal
+	return pkt
+}
+
+// ParseTimestamp extracts the ID, sequence number and the originate, receive
+// and transmit timestamps from an ICMP timestamp request or reply. data must
+// begin at the ICMP header.
+func ParseTimestamp(data []byte) (id, seq uint16, originate, receive, transmit uint32, err error) {
+	if len(data) < 20 {
+		err = fmt.Errorf("timestamp message too short: %d bytes", len(data))
+		return
+	}
+	if data[0] != TypeTimestamp && data[0] != TypeTimestampReply {
+		err = fmt.Errorf("not a timestamp message: type %d", data[0])
+		return
+	}
+	id = binary.BigEndian.Uint16(data[4:6])
+	seq = binary.BigEndian.Uint16(data[6:8])
+	originate = binary.BigEndian.Uint32(data[8:12])
+	receive = binary.BigEndian.Uint32(data[12:16])
+	transmit = binary.BigEndian.Uint32(data[16:20])
+	return
+}
+
+// clockOffset computes the NTP-style clock offset and round trip delay from
+// the local send time t1, remote receive time t2, remote transmit time t3
+// and local receive time t4, together with the two one-way delays.
+func clockOffset(t1, t2, t3, t4 time.Duration) (offset, delay, outbound, inbound time.Duration) {
+	outbound = sinceMidnightDiff(t2, t1)
+	inbound = sinceMidnightDiff(t4, t3)
+	offset = (outbound - inbound) / 2
+	delay = outbound + inbound
+	return
+}
+
+// sinceMidnight returns the time elapsed since the last midnight UT.
+func sinceMidnight(t time.Time) time.Duration {
+	t = t.UTC()
+	return t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// msDuration converts an RFC 792 timestamp to the time of day it denotes.
+func msDuration(ms uint32) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// sinceMidnightDiff returns a-b for two times of day, taking the shortest
+// way around midnight.
+func sinceMidnightDiff(a, b time.Duration) time.Duration {
+	const day = 24 * time.Hour
+	d := (a - b) % day
+	switch {
+	case d > day/2:
+		d -= day
+	case d <= -day/2:
+		d += day
+	}
+	return d
+}
@@ -0,0 +1,87 @@
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTimestampRequest(t *testing.T) {
+	now := time.Date(2025, 1, 1, 1, 2, 3, 456789000, time.UTC)
+	pkt := buildTimestampRequest(7, 9, now)
+	if pkt[0] != TypeTimestamp || len(pkt) != 20 || !ValidateChecksum(pkt) {
+		t.Fatalf("malformed request % x", pkt)
+	}
+	id, seq, originate, receive, transmit, err := ParseTimestamp(pkt)
+	if err != nil || id != 7 || seq != 9 {
+		t.Fatalf("unexpected header id=%d seq=%d err=%v", id, seq, err)
+	}
+	if want := uint32((3723*time.Second + 456*time.Millisecond) / time.Millisecond); originate != want {
+		t.Errorf("expected originate %d, got %d", want, originate)
+	}
+	if receive != 0 || transmit != 0 {
+		t.Errorf("expected empty receive/transmit, got %d/%d", receive, transmit)
+	}
+}
+
+func TestParseTimestamp_Rejects(t *testing.T) {
+	if _, _, _, _, _, err := ParseTimestamp(make([]byte, 12)); err == nil {
+		t.Error("expected error for short message")
+	}
+	pkt := buildEchoRequest(1, 1, time.Now())
+	pkt = append(pkt, make([]byte, 12)...)
+	if _, _, _, _, _, err := ParseTimestamp(pkt); err == nil {
+		t.Error("expected error for echo request")
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	ms := time.Millisecond
+	// The host runs 100ms ahead, each direction takes 10ms and the host
+	// holds the request for 1ms.
+	offset, delay, out, in := clockOffset(1000*ms, 1110*ms, 1111*ms, 1021*ms)
+	if offset != 100*ms || delay != 20*ms || out != 110*ms || in != -90*ms {
+		t.Errorf("got offset=%v delay=%v out=%v in=%v", offset, delay, out, in)
+	}
+}
+
+func TestClockOffset_AcrossMidnight(t *testing.T) {
+	ms := time.Millisecond
+	day := 24 * time.Hour
+	offset, delay, _, _ := clockOffset(day-5*ms, 5*ms, 6*ms, 12*ms)
+	if offset != 2*ms || delay != 16*ms {
+		t.Errorf("got offset=%v delay=%v", offset, delay)
+	}
+}
+
+func TestTimestamp_Fake(t *testing.T) {
+	skew := 2 * time.Second
+	conn := newFakeConn(FamilyIPv4, func(req []byte, dst net.IP, _ int) []fakePacket {
+		now := uint32((sinceMidnight(time.Now()) + skew) / time.Millisecond)
+		msg := append([]byte(nil), req...)
+		msg[0] = TypeTimestampReply
+		binary.BigEndian.PutUint32(msg[12:16], now)
+		binary.BigEndian.PutUint32(msg[16:20], now)
+		setChecksum(FamilyIPv4, msg)
+		return []fakePacket{{msg: msg, from: dst}}
+	})
+	r := Timestamp("192.0.2.1", &Options{Listen: conn.listen})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if d := r.Offset - skew; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("expected offset near %v, got %v", skew, r.Offset)
+	}
+}
+
+func TestTimestamp_RejectsIPv6AndDatagram(t *testing.T) {
+	if r := Timestamp("::1", &Options{Family: FamilyIPv6}); r.Err == nil {
+		t.Error("expected error for IPv6")
+	}
+	r := Timestamp("127.0.0.1", &Options{Socket: SocketDatagram})
+	if r.Err == nil || !strings.Contains(r.Err.Error(), "raw socket") {
+		t.Errorf("expected raw socket error, got %v", r.Err)
+	}
+}
@@ -0,0 +1,167 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Default settings used by Traceroute, matching traceroute(8).
+const (
+	DefaultMaxHops   = 30
+	DefaultHopProbes = 3
+)
+
+// TraceOptions configures Traceroute. The embedded Options.Timeout bounds
+// the wait for the answers of each hop.
+type TraceOptions struct {
+	Options
+	FirstTTL int // TTL of the first hop probed; <= 0 means 1
+	MaxHops  int // highest TTL probed; <= 0 means DefaultMaxHops
+	Probes   int // probes sent per hop; <= 0 means DefaultHopProbes
+}
+
+// HopProbe is the outcome of a single probe sent with a given TTL.
+type HopProbe struct {
+	From string        // address that answered; empty when none did
+	RTT  time.Duration // time until the answer arrived
+	Type uint8         // ICMP type of the answer
+	Code uint8         // ICMP code of the answer
+	Err  error         // set when no answer arrived
+}
+
+// Hop collects the probes sent with one TTL.
+type Hop struct {
+	TTL    int
+	Probes []HopProbe
+}
+
+// Trace is the result of a Traceroute run. The last hop is where the path
+// terminates: either the target itself (Reached) or a router reporting the
+// destination unreachable (Unreachable), unless MaxHops was exhausted.
+type Trace struct {
+	Target      string // target as given by the caller
+	IP          string // resolved address that was traced
+	Hops        []Hop
+	Reached     bool // the target answered with an echo reply
+	Unreachable bool // a destination unreachable message ended the trace
+}
+
+// Traceroute discovers the path to target by sending echo requests with an
+// increasing TTL and collecting the ICMP time exceeded messages of the
+// routers on the way. A nil opts uses the defaults. ICMP errors are only
+// delivered to raw sockets, so root or CAP_NET_RAW is required.
+func Traceroute(target string, opts *TraceOptions) (*Trace, error) {
+	return TracerouteContext(context.Background(), target, opts)
+}
+
+// TracerouteContext is like Traceroute but gives up when ctx is done,
+// returning the hops discovered so far together with the context's error.
+func TracerouteContext(ctx context.Context, target string, opts *TraceOptions) (*Trace, error) {
+	if opts == nil {
+		opts = &TraceOptions{}
+	}
+	if opts.Socket == SocketDatagram {
+		return nil, errors.New("traceroute requires a raw socket")
+	}
+	first, maxHops, probes := opts.FirstTTL, opts.MaxHops, opts.Probes
+	if first <= 0 {
+		first = 1
+	}
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	if probes <= 0 {
+		probes = DefaultHopProbes
+	}
+
+	raddr, fam, err := opts.resolve(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := opts.Options
+	raw.Socket = SocketRaw
+	conn, err := raw.listen(fam)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	defer conn.Close()
+	defer watch(ctx, conn)()
+	sc, err := socketOptions(conn)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+
+	var src net.IP
+	if fam == FamilyIPv6 {
+		src = opts.source(raddr.IP)
+	}
+
+	t := &Trace{Target: target, IP: raddr.IP.String()}
+	id := conn.EchoID(nextID())
+	var seq uint16
+	buf := make([]byte, 1500)
+
+	for ttl := first; ttl <= maxHops; ttl++ {
+		if err := sc.setTTL(ttl); err != nil {
+			return t, fmt.Errorf("socket: %w", err)
+		}
+
+		// Send all probes of the hop back to back, then collect answers.
+		hop := Hop{TTL: ttl, Probes: make([]HopProbe, probes)}
+		sent := make(map[uint16]int, probes)
+		sentAt := make([]time.Time, probes)
+		for i := range hop.Probes {
+			seq++
+			sentAt[i] = time.Now()
+			if _, err := conn.WriteTo(buildEcho(fam, id, seq, timestampPayload(sentAt[i], 8), src, raddr.IP), raddr); err != nil {
+				hop.Probes[i].Err = fmt.Errorf("send: %w", err)
+				continue
+			}
+			sent[seq] = i
+		}
+
+		setReadDeadlineContext(ctx, conn, time.Now().Add(opts.timeout()))
+		for len(sent) > 0 {
+			msg, info, err := conn.ReadFrom(buf)
+			if err != nil {
+				for _, i := range sent {
+					hop.Probes[i].Err = fmt.Errorf("recv: %w", contextError(ctx, err))
+				}
+				break
+			}
+			at, _ := info.received()
+
+			r, ok := parseEchoResponse(fam, id, msg, info.From)
+			if !ok || !r.from.Equal(raddr.IP) {
+				continue
+			}
+			i, ok := sent[r.seq]
+			if !ok {
+				continue
+			}
+			delete(sent, r.seq)
+			hop.Probes[i] = HopProbe{From: info.From.String(), RTT: at.Sub(sentAt[i]), Type: msg[0], Code: msg[1]}
+
+			var unreachable *UnreachableError
+			switch {
+			case r.err == nil:
+				t.Reached = true
+			case errors.As(r.err, &unreachable):
+				t.Unreachable = true
+			}
+		}
+
+		t.Hops = append(t.Hops, hop)
+		if err := ctx.Err(); err != nil {
+			return t, err
+		}
+		if t.Reached || t.Unreachable {
+			break
+		}
+	}
+	return t, nil
+}